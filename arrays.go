@@ -0,0 +1,150 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReconstructArrays rebuilds families of keys with an index segment into
+// slices when configurations are loaded, as flat properties namespaces store
+// arrays, e.g.
+//
+//	hosts.0 = a           hosts = [a, b]
+//	hosts.1 = b      =>
+//	db.0.host = x         db = [{host: x, port: 1}]
+//	db.0.port = 1
+//
+// Elements are scalars or objects whose keys are nested on dots, each element
+// of a slice may be either. Sparse indices are compacted in index order. A
+// family is left as is if its prefix is also a key, or if an element is both
+// a scalar and an object.
+func ReconstructArrays() Option {
+	return optionFunc(func(a *Apollo) {
+		a.reconstructArrays = true
+	})
+}
+
+// arrayFamily is the keys of cfg sharing a prefix followed by an index
+type arrayFamily struct {
+	keys []string
+	// elements maps each index to the keys of the element after the index,
+	// "" for a scalar element
+	elements map[int]map[string]interface{}
+}
+
+// reconstructArrays Replace the key families of cfg by slices
+func reconstructArrays(cfg map[string]interface{}) {
+	families := map[string]*arrayFamily{}
+	for k, v := range cfg {
+		prefix, index, rest, ok := splitIndex(k)
+		if !ok {
+			continue
+		}
+		f, ok := families[prefix]
+		if !ok {
+			f = &arrayFamily{elements: map[int]map[string]interface{}{}}
+			families[prefix] = f
+		}
+		if f.elements[index] == nil {
+			f.elements[index] = map[string]interface{}{}
+		}
+		f.keys = append(f.keys, k)
+		f.elements[index][rest] = v
+	}
+
+	for prefix, f := range families {
+		if _, taken := cfg[prefix]; taken {
+			continue
+		}
+		if slice, ok := f.slice(); ok {
+			for _, k := range f.keys {
+				delete(cfg, k)
+			}
+			cfg[prefix] = slice
+		}
+	}
+}
+
+// slice Build the slice of the family, false if an element is both a scalar
+// and an object
+func (f *arrayFamily) slice() ([]interface{}, bool) {
+	indices := make([]int, 0, len(f.elements))
+	for i := range f.elements {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	slice := make([]interface{}, 0, len(indices))
+	for _, i := range indices {
+		fields := f.elements[i]
+		if scalar, ok := fields[""]; ok {
+			if len(fields) > 1 {
+				return nil, false
+			}
+			slice = append(slice, scalar)
+			continue
+		}
+		reconstructArrays(fields)
+		slice = append(slice, nestKeys(fields))
+	}
+	return slice, true
+}
+
+// splitIndex Split key on its first index segment, e.g. "db.0.host" into "db",
+// 0 and "host", false if it has none
+func splitIndex(key string) (prefix string, index int, rest string, ok bool) {
+	parts := strings.Split(key, ".")
+	for i := 1; i < len(parts); i++ {
+		if n, isIndex := parseIndex(parts[i]); isIndex {
+			return strings.Join(parts[:i], "."), n, strings.Join(parts[i+1:], "."), true
+		}
+	}
+	return "", 0, "", false
+}
+
+// parseIndex Parse s as an index, digits without leading zeros
+func parseIndex(s string) (int, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || s[0] == '+' {
+		return 0, false
+	}
+	return n, true
+}
+
+// nestKeys Return fields with dotted keys nested into maps, keys conflicting
+// with a scalar are kept flat
+func nestKeys(fields map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, k := range sortedKeys(fields) {
+		parts := strings.Split(k, ".")
+		m := out
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(map[string]interface{})
+			if !ok {
+				if _, taken := m[p]; taken {
+					m = nil
+					break
+				}
+				next = map[string]interface{}{}
+				m[p] = next
+			}
+			m = next
+		}
+		leaf := parts[len(parts)-1]
+		if _, nested := m[leaf].(map[string]interface{}); m == nil || nested {
+			out[k] = fields[k]
+			continue
+		}
+		m[leaf] = fields[k]
+	}
+	return out
+}
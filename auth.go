@@ -0,0 +1,97 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessKey holds the secret of an apollo app, either given literally or
+// read from a file which is re-read whenever it is modified
+type accessKey struct {
+	mu      sync.Mutex
+	secret  string
+	path    string
+	modTime time.Time
+}
+
+// AccessKey enables apollo access key authentication with the given secret
+func AccessKey(secret string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.accessKey = &accessKey{secret: secret}
+	})
+}
+
+// AccessKeyFile enables apollo access key authentication with the secret
+// stored in file path, e.g. a mounted kubernetes secret. Surrounding
+// whitespace and newlines are trimmed. The file is read at init and read again
+// when its modification time changes, so rotated secrets take effect without
+// restart.
+func AccessKeyFile(path string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.accessKey = &accessKey{path: path}
+	})
+}
+
+// get Return the current secret, reloading it from file if modified
+func (k *accessKey) get() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.path == "" {
+		return k.secret, nil
+	}
+
+	fi, err := os.Stat(k.path)
+	if err != nil {
+		return "", err
+	}
+	if fi.ModTime().Equal(k.modTime) {
+		return k.secret, nil
+	}
+	b, err := os.ReadFile(k.path)
+	if err != nil {
+		return "", err
+	}
+	k.secret = strings.TrimSpace(string(b))
+	k.modTime = fi.ModTime()
+	return k.secret, nil
+}
+
+// sign Add apollo authentication headers to req if an access key is set
+func (a *Apollo) sign(req *http.Request) error {
+	if a.accessKey == nil {
+		return nil
+	}
+	secret, err := a.accessKey.get()
+	if err != nil || secret == "" {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	// apollo signs the path as sent, escaped
+	pathWithQuery := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		pathWithQuery += "?" + req.URL.RawQuery
+	}
+	req.Header.Set("Authorization", "Apollo "+a.appID+":"+signature(timestamp, pathWithQuery, secret))
+	req.Header.Set("Timestamp", timestamp)
+	return nil
+}
+
+// signature Compute the apollo signature of a request
+func signature(timestamp, pathWithQuery, secret string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + pathWithQuery))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,53 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccessKeyFileRotation(t *testing.T) {
+	var authorization, timestamp, uri string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		timestamp = r.Header.Get("Timestamp")
+		uri = r.URL.RequestURI()
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{}}`))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := InitApollo(Server(ts.URL), AppId("app"), AccessKeyFile(path))
+
+	check := func(secret string) {
+		t.Helper()
+		if _, err := a.load(); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		want := "Apollo app:" + signature(timestamp, uri, secret)
+		if authorization != want {
+			t.Fatalf("expected Authorization %q, got %q", want, authorization)
+		}
+	}
+	check("first")
+
+	if err := os.WriteFile(path, []byte("  second \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	check("second")
+}
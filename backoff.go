@@ -0,0 +1,102 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"time"
+)
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// WatchBackoff sets the delays the watcher waits after failed notification
+// polls: min after the first failure, doubled on each further failure up to
+// max. Defaults are 1s and 60s.
+func WatchBackoff(min, max time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.backoff.min = min
+		a.backoff.max = max
+	})
+}
+
+// BackoffResetAfter sets how many consecutive successful notification polls
+// reset the watch backoff, 1 by default
+func BackoffResetAfter(successes int) Option {
+	return optionFunc(func(a *Apollo) {
+		a.backoff.resetAfter = successes
+	})
+}
+
+// OnRetriesExhausted registers fn, called once threshold consecutive
+// notification polls failed, e.g. to page someone or switch to a degraded
+// mode. fn is called for each watched namespace with the last error, and
+// called again only after a poll succeeded and threshold more failed. The
+// backoff is reset by the first successful poll unless BackoffResetAfter says
+// otherwise.
+func OnRetriesExhausted(threshold int, fn func(namespace string, lastErr error)) Option {
+	return optionFunc(func(a *Apollo) {
+		a.backoff.threshold = threshold
+		a.onRetriesExhausted = fn
+	})
+}
+
+// backoff is the state of the delay between failing notification polls
+type backoff struct {
+	min        time.Duration
+	max        time.Duration
+	resetAfter int
+	// threshold is the number of consecutive failures reported by
+	// OnRetriesExhausted
+	threshold int
+
+	current   time.Duration
+	successes int
+	failures  int
+}
+
+// failure Record a failed poll and return the delay before the next one
+func (b *backoff) failure() time.Duration {
+	b.successes = 0
+	b.failures++
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+// success Record a successful poll, resetting the delay once enough
+// consecutive polls succeeded
+func (b *backoff) success() {
+	b.failures = 0
+	b.successes++
+	if b.successes >= b.resetAfter {
+		b.current = 0
+	}
+}
+
+// exhausted Report whether the last failure reached the threshold of
+// consecutive failures
+func (b *backoff) exhausted() bool {
+	return b.threshold > 0 && b.failures == b.threshold
+}
+
+// retriesExhausted Report err to OnRetriesExhausted for each watched namespace
+func (a *Apollo) retriesExhausted(err error) {
+	if a.onRetriesExhausted == nil {
+		return
+	}
+	for _, namespace := range a.namespaces {
+		namespace := namespace
+		a.safely("OnRetriesExhausted callback", func() { a.onRetriesExhausted(namespace, err) })
+	}
+}
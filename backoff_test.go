@@ -0,0 +1,68 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: time.Second, max: 5 * time.Second, resetAfter: 2}
+	for _, want := range []time.Duration{1, 2, 4, 5, 5} {
+		if got := b.failure(); got != want*time.Second {
+			t.Fatalf("expected %v, got %v", want*time.Second, got)
+		}
+	}
+
+	b.success()
+	if got := b.failure(); got != 5*time.Second {
+		t.Fatalf("a single success should not reset the backoff, got %v", got)
+	}
+	b.success()
+	b.success()
+	if got := b.failure(); got != time.Second {
+		t.Fatalf("expected backoff reset after 2 successes, got %v", got)
+	}
+}
+
+func TestOnRetriesExhausted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var exhausted []string
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "db"), WithLogger(discard),
+		WatchBackoff(time.Millisecond, time.Millisecond),
+		OnRetriesExhausted(3, func(namespace string, lastErr error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if lastErr == nil {
+				t.Errorf("expected the last error of %s", namespace)
+			}
+			exhausted = append(exhausted, namespace)
+		}))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for a.Stats().Errors < 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopWatch(a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exhausted) != 2 || exhausted[0] != "application" || exhausted[1] != "db" {
+		t.Fatalf("expected a single report per namespace, got %v", exhausted)
+	}
+}
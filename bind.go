@@ -0,0 +1,62 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// subtreeBinding is a struct interface bound to the keys under prefix
+type subtreeBinding struct {
+	prefix string
+	object interface{}
+}
+
+// BindSubtree decodes the keys under prefix into obj, e.g. with
+// BindSubtree("db", &dbConfig) the key "db.host" is decoded as "host". Bindings
+// are applied along with the struct interface, on init and on every reload.
+func BindSubtree(prefix string, obj interface{}) Option {
+	return optionFunc(func(a *Apollo) {
+		a.subtrees = append(a.subtrees, subtreeBinding{prefix: prefix, object: obj})
+	})
+}
+
+// bindSubtrees Decode settings into every registered subtree binding
+func (a *Apollo) bindSubtrees(settings map[string]interface{}) {
+	for _, b := range a.subtrees {
+		d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: a.structHook(),
+			Result:     b.object,
+		})
+		if err != nil {
+			a.decodeError("subtree:"+b.prefix, err)
+			continue
+		}
+		if err := d.Decode(subtree(settings, b.prefix)); err != nil {
+			a.decodeError("subtree:"+b.prefix, err)
+		}
+	}
+}
+
+// subtree Return the settings under prefix, whether they are nested under the
+// prefix key or flat keys starting with "prefix."
+func subtree(settings map[string]interface{}, prefix string) map[string]interface{} {
+	prefix = strings.ToLower(prefix)
+	out := map[string]interface{}{}
+	if nested, ok := settings[prefix].(map[string]interface{}); ok {
+		for k, v := range nested {
+			out[k] = v
+		}
+	}
+	for k, v := range settings {
+		if strings.HasPrefix(k, prefix+".") {
+			out[strings.TrimPrefix(k, prefix+".")] = v
+		}
+	}
+	return out
+}
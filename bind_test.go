@@ -0,0 +1,66 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBindSubtrees(t *testing.T) {
+	type dbConfig struct {
+		Host string `mapstructure:"host"`
+		Port int    `mapstructure:"port"`
+	}
+	type cacheConfig struct {
+		TTL int `mapstructure:"ttl"`
+	}
+	db, cache := dbConfig{}, cacheConfig{}
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), WithLogger(discard),
+		BindSubtree("db", &db), BindSubtree("Cache", &cache))
+
+	a.bindSubtrees(map[string]interface{}{
+		"db.host": "localhost",
+		"db.port": "5432",
+		"cache": map[string]interface{}{
+			"ttl": 30,
+		},
+		"other": "x",
+	})
+	if db.Host != "localhost" || db.Port != 5432 {
+		t.Fatalf("unexpected db config %+v", db)
+	}
+	if cache.TTL != 30 {
+		t.Fatalf("unexpected cache config %+v", cache)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"port":"8080"}}`))
+	}))
+	defer ts.Close()
+
+	var cfg struct {
+		Port int `mapstructure:"port"`
+	}
+	a := InitApollo(Server(ts.URL), AppId("app"), Struct(&cfg), IgnoreLocalSettings(), WithLogger(discard))
+	useRemote(t, a)
+	if err := Remote.ReadRemoteConfig(); err != nil {
+		t.Fatalf("ReadRemoteConfig: %v", err)
+	}
+	Remote.Set("port", 9090)
+	if err := a.Rebind(); err != nil {
+		t.Fatalf("Rebind: %v", err)
+	}
+	if cfg.Port != 9090 || atomic.LoadInt32(&fetches) != 1 {
+		t.Fatalf("expected the override bound without fetching, got port %d after %d fetches", cfg.Port, atomic.LoadInt32(&fetches))
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+// Builder builds an Apollo with chainable methods, as an alternative to the
+// list of options of InitApollo, e.g.
+//
+//	apollo, err := vapollo.NewBuilder().
+//		Server("127.0.0.1:8080").
+//		AppID("TestApp").
+//		Namespace("application", "common").
+//		Build()
+//
+// Options without a method of their own are added with With.
+type Builder struct {
+	opts       []Option
+	namespaces []string
+}
+
+// NewBuilder returns an empty Builder
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Server sets the address of apollo, see Server
+func (b *Builder) Server(s string) *Builder {
+	return b.With(Server(s))
+}
+
+// AppID sets the app id, see AppId
+func (b *Builder) AppID(app string) *Builder {
+	return b.With(AppId(app))
+}
+
+// Cluster sets the cluster, "default" unless set, see Cluster
+func (b *Builder) Cluster(c string) *Builder {
+	return b.With(Cluster(c))
+}
+
+// Namespace adds namespaces to the ones loaded from apollo, see Namespaces.
+// Namespaces added first take precedence.
+func (b *Builder) Namespace(namespaces ...string) *Builder {
+	b.namespaces = append(b.namespaces, namespaces...)
+	return b
+}
+
+// AccessKey enables access key authentication, see AccessKey
+func (b *Builder) AccessKey(secret string) *Builder {
+	return b.With(AccessKey(secret))
+}
+
+// Struct sets the struct bound to configuration, see Struct
+func (b *Builder) Struct(obj interface{}) *Builder {
+	return b.With(Struct(obj))
+}
+
+// OnChange sets the function called when configuration changed, see OnChange
+func (b *Builder) OnChange(fn func()) *Builder {
+	return b.With(OnChange(fn))
+}
+
+// With adds options, applied in order after the ones added before
+func (b *Builder) With(opts ...Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build creates the Apollo with the options accumulated, validated as
+// InitApollo does, but returns an error instead of panicking when they are
+// invalid, e.g. ErrMissingArguments
+func (b *Builder) Build() (*Apollo, error) {
+	opts := b.opts
+	if len(b.namespaces) > 0 {
+		opts = append(opts[:len(opts):len(opts)], Namespaces(append([]string(nil), b.namespaces...)...))
+	}
+	return buildApollo(opts...)
+}
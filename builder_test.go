@@ -0,0 +1,66 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	}))
+	defer ts.Close()
+
+	a, err := NewBuilder().
+		Server(ts.URL).
+		AppID("app").
+		Cluster("dev").
+		Namespace("application").
+		Namespace("common").
+		With(WithLogger(discard)).
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if a.appID != "app" || a.cluster != "dev" {
+		t.Fatalf("unexpected app %q, cluster %q", a.appID, a.cluster)
+	}
+	if want := []string{"application", "common"}; !reflect.DeepEqual(a.namespaces, want) {
+		t.Fatalf("expected namespaces %v, got %v", want, a.namespaces)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	if _, err := NewBuilder().AppID("app").Build(); !errors.Is(err, ErrMissingArguments) {
+		t.Fatalf("expected ErrMissingArguments, got %v", err)
+	}
+	_, err := NewBuilder().
+		Server("127.0.0.1:8080").
+		AppID("app").
+		With(MaxNamespaces(1)).
+		Namespace("a", "b").
+		Build()
+	if !errors.Is(err, ErrTooManyNamespaces) {
+		t.Fatalf("expected ErrTooManyNamespaces, got %v", err)
+	}
+
+	_, err = NewBuilder().Server("127.0.0.1:8080").AppID("app").With(Proxy("http://proxy:%zz")).Build()
+	if err == nil || !strings.Contains(err.Error(), "invalid proxy") {
+		t.Fatalf("expected an invalid proxy error, got %v", err)
+	}
+	_, err = NewBuilder().Server("127.0.0.1:8080").AppID("app").
+		With(HTTPClient(&http.Client{Transport: roundTripperFunc(nil)}), TLSServerName("apollo")).
+		Build()
+	if err == nil || !strings.Contains(err.Error(), "*http.Transport") {
+		t.Fatalf("expected a transport error, got %v", err)
+	}
+}
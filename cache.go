@@ -0,0 +1,186 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheDir enables the disk cache: configurations loaded from apollo are
+// written to dir, and read back from it when apollo can't be reached
+func CacheDir(dir string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.cacheDir = dir
+	})
+}
+
+// CompressCache gzips the files written to the disk cache, which is worth it
+// for large namespaces. Both compressed and plain files are read back.
+func CompressCache() Option {
+	return optionFunc(func(a *Apollo) {
+		a.compressCache = true
+	})
+}
+
+// PersistNotifications keeps the notification ids of namespaces in the cache
+// dir, so that a restarting instance resumes watching from them instead of
+// being notified of every namespace again. Configurations are still read once
+// on start. It requires CacheDir.
+func PersistNotifications() Option {
+	return optionFunc(func(a *Apollo) {
+		a.persistNotifications = true
+	})
+}
+
+// ClearCache removes the cached configurations of this app/cluster and its
+// namespaces, so that they are not used as fallback anymore
+func (a *Apollo) ClearCache() error {
+	if a.cacheDir == "" {
+		return nil
+	}
+	for _, namespace := range a.namespaces {
+		err := os.Remove(a.cacheFile(namespace))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheFile Return the path of the cache file of namespace
+func (a *Apollo) cacheFile(namespace string) string {
+	s := a.sourceOf(namespace)
+	name := strings.Join([]string{s.AppID, s.Cluster, s.Namespace}, "+") + ".json"
+	return filepath.Join(a.cacheDir, name)
+}
+
+// writeCache Write configurations of namespace to the disk cache. The file is
+// written aside then renamed, so that a crash never leaves a truncated cache.
+func (a *Apollo) writeCache(namespace string, b []byte) error {
+	if a.cacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(a.cacheDir, 0700); err != nil {
+		return err
+	}
+	if a.compressCache {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(b); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+
+	return writeFileAtomic(a.cacheFile(namespace), b)
+}
+
+// writeFileAtomic Write b to a temporary file next to path then rename it, so
+// that a crash never leaves path truncated. The file is only readable by its
+// owner.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readCache Read configurations of namespace from the disk cache, whether the
+// file was gzipped or not
+func (a *Apollo) readCache(namespace string) ([]byte, error) {
+	if a.cacheDir == "" {
+		return nil, errors.New("disk cache disabled")
+	}
+	b, err := os.ReadFile(a.cacheFile(namespace))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// gzipMagic starts every gzip stream, while cached json starts with '{'
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// notificationsFile Return the path of the file keeping notification ids
+func (a *Apollo) notificationsFile() string {
+	name := strings.Join([]string{a.appID, a.currentCluster()}, "+") + ".notifications.json"
+	return filepath.Join(a.cacheDir, name)
+}
+
+// saveNotifications Write notification ids to the cache dir
+func (a *Apollo) saveNotifications() error {
+	if !a.persistNotifications || a.cacheDir == "" {
+		return nil
+	}
+	b, err := a.json().Marshal(a.notifications)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(a.cacheDir, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(a.notificationsFile(), b)
+}
+
+// restoreNotifications Resume notification ids of configured namespaces from
+// the cache dir, report whether any was restored
+func (a *Apollo) restoreNotifications() bool {
+	if !a.persistNotifications || a.cacheDir == "" {
+		return false
+	}
+	b, err := os.ReadFile(a.notificationsFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.logger.Printf("Failed reading notification ids: %v", err)
+		}
+		return false
+	}
+	var saved []notification
+	if err := a.json().Unmarshal(b, &saved); err != nil {
+		a.logger.Printf("Failed reading notification ids: %v", err)
+		return false
+	}
+	restored := false
+	for _, s := range saved {
+		for i := range a.notifications {
+			if a.notifications[i].NamespaceName == s.NamespaceName {
+				a.notifications[i].NotificationID = s.NotificationID
+				restored = true
+			}
+		}
+	}
+	return restored
+}
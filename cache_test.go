@@ -0,0 +1,142 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kyeason/vapollo/testutil"
+)
+
+func TestDiskCacheFallbackAndClear(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	a := InitApollo(Server(ts.URL), AppId("app"), CacheDir(t.TempDir()), WithLogger(discard))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ts.Close()
+
+	b, err := a.load()
+	if err != nil {
+		t.Fatalf("expected fallback to disk cache, got %v", err)
+	}
+	if string(b) != `{"a":"1"}` {
+		t.Fatalf("unexpected cached configurations %s", b)
+	}
+
+	if err := a.ClearCache(); err != nil {
+		t.Fatalf("ClearCache: %v", err)
+	}
+	if _, err := a.load(); err == nil {
+		t.Fatal("expected an error once the cache is cleared")
+	}
+	if err := a.ClearCache(); err != nil {
+		t.Fatalf("ClearCache on an empty cache: %v", err)
+	}
+}
+
+func TestDiskCacheKeepsSecretReferences(t *testing.T) {
+	ts := newFakeApollo(`{"db.password":"secret://vault/db"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), CacheDir(t.TempDir()),
+		SecretResolver(func(ref string) (string, error) { return "p4ss", nil }))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	b, err := a.readCache("application")
+	if err != nil {
+		t.Fatalf("readCache: %v", err)
+	}
+	if strings.Contains(string(b), "p4ss") {
+		t.Fatalf("resolved secret written to disk cache: %s", b)
+	}
+}
+
+func TestCompressedDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	a := InitApollo(Server("http://localhost"), AppId("app"), CacheDir(dir), CompressCache())
+	if err := a.writeCache("application", []byte(`{"a":"1"}`)); err != nil {
+		t.Fatalf("writeCache: %v", err)
+	}
+	raw, err := os.ReadFile(a.cacheFile("application"))
+	if err != nil {
+		t.Fatalf("read cache file: %v", err)
+	}
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		t.Fatalf("expected a gzipped cache file, got %q", raw)
+	}
+	b, err := a.readCache("application")
+	if err != nil || string(b) != `{"a":"1"}` {
+		t.Fatalf("expected decompressed configurations, got %s, %v", b, err)
+	}
+
+	// Plain files written before compression was enabled are still read
+	if err := os.WriteFile(a.cacheFile("application"), []byte(`{"a":"2"}`), 0600); err != nil {
+		t.Fatalf("write plain cache file: %v", err)
+	}
+	if b, err := a.readCache("application"); err != nil || string(b) != `{"a":"2"}` {
+		t.Fatalf("expected plain configurations, got %s, %v", b, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the cache file to be left, got %d entries", len(entries))
+	}
+}
+
+func TestPersistNotifications(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{Server("http://localhost"), AppId("app"), Namespaces("application", "shared"),
+		CacheDir(dir), PersistNotifications(), WithLogger(discard)}
+	a := InitApollo(opts...)
+	if a.resumed {
+		t.Fatal("expected nothing to resume on first start")
+	}
+	a.updateNotifications([]notification{{NamespaceName: "shared", NotificationID: 42}})
+
+	restarted := InitApollo(opts...)
+	if !restarted.resumed {
+		t.Fatal("expected notification ids to be resumed")
+	}
+	if got := restarted.notifications[1].NotificationID; got != 42 {
+		t.Fatalf("expected shared to resume from 42, got %d", got)
+	}
+	if got := restarted.notifications[0].NotificationID; got != -1 {
+		t.Fatalf("expected application to start from -1, got %d", got)
+	}
+}
+
+func TestPersistNotificationsStillLoadsOnRestart(t *testing.T) {
+	s := testutil.NewServer()
+	defer s.Close()
+	s.Publish("application", map[string]string{"a": "1"})
+	dir := t.TempDir()
+	opts := []Option{Server(s.URL), AppId("app"), CacheDir(dir), PersistNotifications(), WithLogger(discard)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		a := InitApollo(opts...)
+		useRemote(t, a)
+		if err := a.StartWatch(); err != nil {
+			t.Fatalf("StartWatch: %v", err)
+		}
+		err := a.WaitForRelease(ctx, s.ReleaseKey("application"))
+		stopWatch(a)
+		if err != nil {
+			t.Fatalf("start %d: waiting for release: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+// CallbackMode tells how OnChange and OnBatchChange callbacks are run
+type CallbackMode int
+
+const (
+	// CallbackSync runs callbacks on the watch loop, which waits for them
+	// before polling again. Callbacks run one at a time in the order
+	// modifications were read, and see the struct interface updated.
+	CallbackSync CallbackMode = iota
+	// CallbackAsync runs callbacks on their own goroutines, at most
+	// maxAsyncCallbacks at once, so that a slow callback doesn't delay
+	// refreshing configuration. Callbacks may run concurrently and out of
+	// order, and may see configuration more recent than the modification
+	// that triggered them.
+	CallbackAsync
+)
+
+// maxAsyncCallbacks bounds the callbacks running at once in CallbackAsync mode
+const maxAsyncCallbacks = 4
+
+// WithCallbackMode sets how callbacks are run, CallbackSync by default. Use
+// CallbackAsync when callbacks do heavy work, e.g. rebuilding clients.
+func WithCallbackMode(mode CallbackMode) Option {
+	return optionFunc(func(a *Apollo) {
+		a.callbackMode = mode
+		if mode == CallbackAsync {
+			a.callbackSlots = make(chan struct{}, maxAsyncCallbacks)
+		}
+	})
+}
+
+// runCallback Run fn as CallbackMode tells, recovering from any panic
+func (a *Apollo) runCallback(step string, fn func()) {
+	if a.callbackMode != CallbackAsync {
+		a.safely(step, fn)
+		return
+	}
+	go func() {
+		a.callbackSlots <- struct{}{}
+		defer func() { <-a.callbackSlots }()
+		a.safely(step, fn)
+	}()
+}
@@ -0,0 +1,60 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCallbackAsync(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	release := make(chan struct{})
+	var running, finished int32
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard), WithCallbackMode(CallbackAsync),
+		OnChange(func() {
+			atomic.AddInt32(&running, 1)
+			<-release
+			atomic.AddInt32(&finished, 1)
+		}))
+	useRemote(t, a)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < maxAsyncCallbacks+2; i++ {
+			a.applyChange()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected changes to be applied without waiting for callbacks")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&running) < maxAsyncCallbacks {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d callbacks running, got %d", maxAsyncCallbacks, atomic.LoadInt32(&running))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&running); n != maxAsyncCallbacks {
+		t.Fatalf("expected at most %d callbacks running at once, got %d", maxAsyncCallbacks, n)
+	}
+
+	close(release)
+	for atomic.LoadInt32(&finished) < maxAsyncCallbacks+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected all callbacks to run, got %d", atomic.LoadInt32(&finished))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultMaxResponseBytes is the default size limit of apollo responses
+const defaultMaxResponseBytes = 4 << 20
+
+// MaxResponseBytes limits the size of apollo responses to n bytes, 4MB by
+// default, so that a misbehaving server can't exhaust memory. Larger
+// responses fail with ErrResponseTooLarge.
+func MaxResponseBytes(n int64) Option {
+	return optionFunc(func(a *Apollo) {
+		a.maxResponseBytes = n
+	})
+}
+
+// HTTPClient sets the client used to send requests to apollo, by default an
+// internal client whose transport is tuned for notification long polls
+func HTTPClient(c *http.Client) Option {
+	return optionFunc(func(a *Apollo) {
+		a.client = c
+	})
+}
+
+// TransportWrapper wraps the transport of the client sending requests to
+// apollo, e.g. to add tracing, metrics or retry middleware:
+//
+//	vapollo.TransportWrapper(func(rt http.RoundTripper) http.RoundTripper {
+//		return otelhttp.NewTransport(rt)
+//	})
+//
+// Wrappers are applied in the given order, the first one being the innermost.
+func TransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return optionFunc(func(a *Apollo) {
+		a.transportWrappers = append(a.transportWrappers, wrap)
+	})
+}
+
+// TLSServerName sets the name verified against the certificate of apollo, e.g.
+// when reaching it through a load balancer whose certificate doesn't match
+// the dialed host, rather than disabling verification. Like the other
+// transport options, it applies to the transport of the client, which must be
+// an *http.Transport.
+func TLSServerName(name string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.transportOptions = append(a.transportOptions, func(t *http.Transport) {
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			t.TLSClientConfig.ServerName = name
+		})
+	})
+}
+
+// Proxy sends requests to apollo through the proxy at addr, e.g.
+// "http://proxy:3128", instead of the proxy of the HTTP_PROXY and HTTPS_PROXY
+// environment variables. It applies to the transport of the client, which must
+// be an *http.Transport.
+func Proxy(addr string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.proxy = addr
+	})
+}
+
+// ProxyAuth authenticates requests to the proxy with basic auth, the proxy
+// being set by Proxy or the environment. It applies to the transport of the
+// client, which must be an *http.Transport.
+func ProxyAuth(user, pass string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.proxyUser = url.UserPassword(user, pass)
+	})
+}
+
+// proxyFunc Return the proxy function set up by Proxy and ProxyAuth
+func (a *Apollo) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	proxy := http.ProxyFromEnvironment
+	if a.proxy != "" {
+		u, err := url.Parse(a.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	if a.proxyUser == nil {
+		return proxy, nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if u == nil || err != nil {
+			return u, err
+		}
+		authenticated := *u
+		authenticated.User = a.proxyUser
+		return &authenticated, nil
+	}, nil
+}
+
+// defaultResponseHeaderTimeout is how long the internal client waits for
+// response headers, longer than the 60 seconds apollo holds notification polls
+const defaultResponseHeaderTimeout = 90 * time.Second
+
+// defaultIdleConnTimeout is how long the internal client keeps idle
+// connections between polls
+const defaultIdleConnTimeout = 90 * time.Second
+
+// IdleConnTimeout sets how long idle connections to apollo are kept open,
+// 90 seconds by default. Lower it below the idle timeout of proxies between
+// the application and apollo, so that connections they dropped aren't reused.
+func IdleConnTimeout(d time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.transportOptions = append(a.transportOptions, func(t *http.Transport) {
+			t.IdleConnTimeout = d
+		})
+	})
+}
+
+// ResponseHeaderTimeout sets how long to wait for the headers of apollo
+// responses, 90 seconds by default, so that a poll whose connection was
+// silently dropped fails rather than hangs. It must exceed the 60 seconds
+// apollo holds notification polls.
+func ResponseHeaderTimeout(d time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.transportOptions = append(a.transportOptions, func(t *http.Transport) {
+			t.ResponseHeaderTimeout = d
+		})
+	})
+}
+
+// ForceAttemptHTTP2 sets whether HTTP/2 is attempted with apollo, enabled by
+// default. Multiplexing the long polls over a single connection keeps it busy
+// for intermediaries dropping idle connections.
+func ForceAttemptHTTP2(enabled bool) Option {
+	return optionFunc(func(a *Apollo) {
+		a.transportOptions = append(a.transportOptions, func(t *http.Transport) {
+			t.ForceAttemptHTTP2 = enabled
+		})
+	})
+}
+
+// longPollTransport Return the transport of the internal client, tuned for
+// notification long polls
+func longPollTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.IdleConnTimeout = defaultIdleConnTimeout
+	t.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	t.ForceAttemptHTTP2 = true
+	return t
+}
+
+// RequestMutator registers mutate, invoked on every request to apollo just
+// before it is sent, e.g. to add a bearer token or a tenant header. It runs
+// after the built-in headers are set, access key signature included, so its
+// changes win; changing the URL invalidates the signature. Mutators run in the
+// given order, an error aborts the request.
+func RequestMutator(mutate func(*http.Request) error) Option {
+	return optionFunc(func(a *Apollo) {
+		a.requestMutators = append(a.requestMutators, mutate)
+	})
+}
+
+// initClient Build the client sending requests to apollo. A client provided
+// by HTTPClient is copied rather than modified.
+func (a *Apollo) initClient() error {
+	if a.client == nil {
+		a.client = &http.Client{Transport: longPollTransport()}
+	}
+	if a.proxy != "" || a.proxyUser != nil {
+		proxy, err := a.proxyFunc()
+		if err != nil {
+			return err
+		}
+		a.transportOptions = append(a.transportOptions, func(t *http.Transport) {
+			t.Proxy = proxy
+		})
+	}
+	if len(a.transportWrappers) == 0 && len(a.transportOptions) == 0 {
+		return nil
+	}
+
+	transport := a.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if len(a.transportOptions) > 0 {
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("transport options need an *http.Transport, got %T", transport)
+		}
+		t = t.Clone()
+		for _, configure := range a.transportOptions {
+			configure(t)
+		}
+		a.clonedTransport = t
+		transport = t
+	}
+	for _, wrap := range a.transportWrappers {
+		transport = wrap(transport)
+	}
+	c := *a.client
+	c.Transport = transport
+	a.client = &c
+	return nil
+}
+
+// RateLimit limits the requests sent to apollo by this instance to rps per
+// second, to protect apollo when many instances restart at once. Requests
+// exceeding the limit wait for their turn rather than fail.
+func RateLimit(rps int) Option {
+	return optionFunc(func(a *Apollo) {
+		if rps > 0 {
+			a.limiter = rate.NewLimiter(rate.Limit(rps), rps)
+		}
+	})
+}
+
+// StartupJitter delays the first request sent to apollo by a random duration
+// up to max, which spreads the load of instances starting together
+func StartupJitter(max time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.startupJitter = max
+	})
+}
+
+// throttle Wait for the startup jitter and the rate limiter before sending a
+// request
+func (a *Apollo) throttle(ctx context.Context) error {
+	a.jitterOnce.Do(func() {
+		if a.startupJitter > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(a.startupJitter))))
+		}
+	})
+	if a.limiter == nil {
+		return nil
+	}
+	return a.limiter.Wait(ctx)
+}
+
+// readBody Read a response body up to the size limit
+func (a *Apollo) readBody(r io.Reader) ([]byte, error) {
+	max := a.maxResponseBytes
+	if max <= 0 {
+		max = defaultMaxResponseBytes
+	}
+	b, err := io.ReadAll(io.LimitReader(r, max+1))
+	a.recordBytes(len(b))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, max)
+	}
+	return b, nil
+}
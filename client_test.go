@@ -0,0 +1,178 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestTransportWrapper(t *testing.T) {
+	var order string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = r.Header.Get("X-Order")
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{}}`))
+	}))
+	defer ts.Close()
+
+	wrapper := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req.Header.Add("X-Order", name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	client := &http.Client{}
+	a := InitApollo(Server(ts.URL), AppId("app"), HTTPClient(client),
+		TransportWrapper(wrapper("inner")), TransportWrapper(wrapper("outer")))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if order != "outer" {
+		t.Fatalf("expected the last wrapper to run first, got %q", order)
+	}
+	if client.Transport != nil {
+		t.Fatal("provided client should not be modified")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := newFakeApollo(`{}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), RateLimit(50))
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		if _, err := a.load(); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected requests beyond the burst to be throttled, took %v", elapsed)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	ts := newFakeApollo(`{"a":"` + strings.Repeat("x", 100) + `"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), MaxResponseBytes(64))
+	if _, err := a.load(); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+	}
+	a = InitApollo(Server(ts.URL), AppId("app"))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load within the default limit: %v", err)
+	}
+}
+
+func TestRequestMutator(t *testing.T) {
+	var tenant, auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, auth = r.Header.Get("X-Tenant"), r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{},"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), AccessKey("s3cr3t"),
+		RequestMutator(func(req *http.Request) error {
+			req.Header.Set("X-Tenant", "t1")
+			return nil
+		}),
+		RequestMutator(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer token")
+			return nil
+		}))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tenant != "t1" || auth != "Bearer token" {
+		t.Fatalf("expected mutations to win, got tenant=%q auth=%q", tenant, auth)
+	}
+
+	failing := errors.New("no token")
+	a = InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard),
+		RequestMutator(func(req *http.Request) error { return failing }))
+	if _, err := a.load(); !errors.Is(err, failing) {
+		t.Fatalf("expected mutator error, got %v", err)
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	}))
+	defer ts.Close()
+
+	// the test certificate is valid for example.com, not for other.test
+	for name, valid := range map[string]bool{"example.com": true, "other.test": false} {
+		a := InitApollo(Server(ts.URL), AppId("app"), HTTPClient(ts.Client()), TLSServerName(name), WithLogger(discard))
+		_, err := a.load()
+		if valid && err != nil {
+			t.Fatalf("%s: load: %v", name, err)
+		}
+		if !valid && err == nil {
+			t.Fatalf("%s: expected certificate verification to fail", name)
+		}
+	}
+	if ts.Client().Transport.(*http.Transport).TLSClientConfig.ServerName != "" {
+		t.Fatalf("expected the provided client not to be modified")
+	}
+}
+
+func TestTransportTuning(t *testing.T) {
+	a := InitApollo(Server("http://localhost:8080"), AppId("app"), WithLogger(discard))
+	transport := a.client.Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != defaultResponseHeaderTimeout || transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Fatalf("expected long poll defaults, got %v and %v", transport.ResponseHeaderTimeout, transport.IdleConnTimeout)
+	}
+
+	a = InitApollo(Server("http://localhost:8080"), AppId("app"), WithLogger(discard),
+		IdleConnTimeout(30*time.Second), ResponseHeaderTimeout(75*time.Second), ForceAttemptHTTP2(false))
+	transport = a.client.Transport.(*http.Transport)
+	if transport.IdleConnTimeout != 30*time.Second || transport.ResponseHeaderTimeout != 75*time.Second || transport.ForceAttemptHTTP2 {
+		t.Fatalf("expected tuned transport, got %+v", transport)
+	}
+}
+
+func TestProxyAuth(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := parseProxyAuth(r.Header.Get("Proxy-Authorization"))
+		if user != "u" || pass != "p" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		proxied = r.URL.Host
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	}))
+	defer proxy.Close()
+
+	a := InitApollo(Server("http://apollo.test"), AppId("app"), Proxy(proxy.URL), ProxyAuth("u", "p"), WithLogger(discard))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if proxied != "apollo.test" {
+		t.Fatalf("expected the request proxied to apollo.test, got %q", proxied)
+	}
+}
+
+// parseProxyAuth Decode basic credentials of a Proxy-Authorization header
+func parseProxyAuth(header string) (user, pass string, ok bool) {
+	r := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return r.BasicAuth()
+}
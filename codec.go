@@ -0,0 +1,63 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// JSONCodec marshals and unmarshals the json exchanged with apollo, e.g. to
+// plug jsoniter in place of encoding/json:
+//
+//	vapollo.WithJSONCodec(jsoniter.Config{UseNumber: true}.Froze())
+//
+// Codecs should decode numbers as json.Number, as the default one does, so
+// that large integers don't lose precision.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// WithJSONCodec replaces encoding/json to decode apollo responses and encode
+// configurations. StrictResponse still decodes responses with encoding/json.
+func WithJSONCodec(codec JSONCodec) Option {
+	return optionFunc(func(a *Apollo) {
+		a.codec = codec
+	})
+}
+
+// stdCodec is the default codec based on encoding/json
+type stdCodec struct{}
+
+func (stdCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (stdCodec) Unmarshal(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// json Return the codec in use
+func (a *Apollo) json() JSONCodec {
+	if a.codec == nil {
+		return stdCodec{}
+	}
+	return a.codec
+}
+
+// structHook Return the decode hook of JsonStructInMapHookFunc, decoding json
+// strings with the codec if one was provided
+func (a *Apollo) structHook() mapstructure.DecodeHookFunc {
+	if a.codec == nil {
+		return JsonStructInMapHookFunc()
+	}
+	return jsonStructInMapHookFunc(a.codec.Unmarshal)
+}
@@ -0,0 +1,64 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+)
+
+// countingCodec is encoding/json counting its calls
+type countingCodec struct {
+	marshals, unmarshals int32
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt32(&c.marshals, 1)
+	return json.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	atomic.AddInt32(&c.unmarshals, 1)
+	return json.Unmarshal(data, v)
+}
+
+func TestWithJSONCodec(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	codec := &countingCodec{}
+	a := InitApollo(Server(ts.URL), AppId("app"), WithJSONCodec(codec))
+	b, err := a.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if string(b) != `{"a":"1"}` {
+		t.Fatalf("unexpected configurations %s", b)
+	}
+	if _, err := a.getNotifications(); err != nil {
+		t.Fatalf("getNotifications: %v", err)
+	}
+	// response and configurations, then the notifications
+	if got := atomic.LoadInt32(&codec.unmarshals); got != 3 {
+		t.Fatalf("expected 3 unmarshals through the codec, got %d", got)
+	}
+	// merged configurations, then the notifications body
+	if got := atomic.LoadInt32(&codec.marshals); got != 2 {
+		t.Fatalf("expected 2 marshals through the codec, got %d", got)
+	}
+}
+
+func TestDefaultCodecKeepsNumbers(t *testing.T) {
+	a := InitApollo(Server("http://localhost"), AppId("app"))
+	cfg, err := a.decodeConfig([]byte(`{"id":9007199254740993}`))
+	if err != nil {
+		t.Fatalf("decodeConfig: %v", err)
+	}
+	if got, ok := cfg["id"].(json.Number); !ok || got.String() != "9007199254740993" {
+		t.Fatalf("expected json.Number 9007199254740993, got %#v", cfg["id"])
+	}
+}
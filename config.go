@@ -0,0 +1,110 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Keys returns the keys of the configuration last loaded from apollo, sorted
+// so that the output is stable across runs
+func (a *Apollo) Keys() []string {
+	_ = a.Load()
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	return sortedKeys(a.config)
+}
+
+// GetDuration returns the value of key in the configuration last loaded from
+// apollo parsed as a duration, e.g. "30s"
+func (a *Apollo) GetDuration(key string) (time.Duration, error) {
+	s, err := a.getString(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("key %q is not a duration: %w", key, err)
+	}
+	return d, nil
+}
+
+// GetTime returns the value of key in the configuration last loaded from
+// apollo parsed as an RFC3339 time, e.g. "2022-03-01T08:00:00Z"
+func (a *Apollo) GetTime(key string) (time.Time, error) {
+	s, err := a.getString(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("key %q is not an RFC3339 time: %w", key, err)
+	}
+	return t, nil
+}
+
+// getString Return the string value of key
+func (a *Apollo) getString(key string) (string, error) {
+	if err := a.Load(); err != nil {
+		return "", err
+	}
+	a.configMu.RLock()
+	v, ok := a.config[key]
+	a.configMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("key %q not found", key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q is not a string: %v", key, v)
+	}
+	return s, nil
+}
+
+func (a *Apollo) setConfig(cfg map[string]interface{}, source ConfigSource) {
+	a.configMu.Lock()
+	a.config = cfg
+	a.source = source
+	a.configMu.Unlock()
+}
+
+// configCopy Return a shallow copy of the configuration last loaded from
+// apollo
+func (a *Apollo) configCopy() map[string]interface{} {
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	cfg := make(map[string]interface{}, len(a.config))
+	for k, v := range a.config {
+		cfg[k] = v
+	}
+	return cfg
+}
+
+// decodeConfig Decode configurations of an apollo response, numbers are kept
+// as json.Number to avoid losing precision
+func (a *Apollo) decodeConfig(b []byte) (map[string]interface{}, error) {
+	cfg := map[string]interface{}{}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return cfg, nil
+	}
+	if err := a.json().Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// sortedKeys Return the keys of m in ascending order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeysSorted(t *testing.T) {
+	ts := newFakeApollo(`{"c":"3","a":"1","b":"2"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"))
+	if keys := a.Keys(); len(keys) != 0 {
+		t.Fatalf("expected no keys before loading, got %v", keys)
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if keys := a.Keys(); !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestGetDurationAndTime(t *testing.T) {
+	a := InitApollo(Server("http://localhost"), AppId("app"))
+	a.setConfig(map[string]interface{}{
+		"timeout":   "30s",
+		"releaseAt": "2022-03-01T08:00:00Z",
+		"bad":       "soon",
+		"number":    json.Number("30"),
+	}, SourceRemote)
+
+	if d, err := a.GetDuration("timeout"); err != nil || d != 30*time.Second {
+		t.Fatalf("expected 30s, got %v, %v", d, err)
+	}
+	want := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	if tm, err := a.GetTime("releaseAt"); err != nil || !tm.Equal(want) {
+		t.Fatalf("expected %v, got %v, %v", want, tm, err)
+	}
+	for _, key := range []string{"bad", "number", "missing"} {
+		if _, err := a.GetDuration(key); err == nil {
+			t.Fatalf("expected an error for duration %s", key)
+		}
+		if _, err := a.GetTime(key); err == nil {
+			t.Fatalf("expected an error for time %s", key)
+		}
+	}
+}
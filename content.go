@@ -0,0 +1,100 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// contentKey is the key under which apollo serves the content of namespaces
+// which aren't in properties format
+const contentKey = "content"
+
+// NamespaceFormat sets the format of namespace, e.g. "yaml", overriding the
+// format named by its suffix, e.g. for a namespace named app.yml or a .txt
+// namespace holding json. The content of the namespace is then parsed with the
+// viper config type of format.
+func NamespaceFormat(namespace, format string) Option {
+	return optionFunc(func(a *Apollo) {
+		if a.namespaceFormats == nil {
+			a.namespaceFormats = map[string]string{}
+		}
+		a.namespaceFormats[namespace] = format
+	})
+}
+
+// formatOf Return the format of namespace, set by NamespaceFormat or named by
+// its suffix, properties if it has none
+func (a *Apollo) formatOf(namespace string) string {
+	if format, ok := a.namespaceFormats[namespace]; ok {
+		return format
+	}
+	format := strings.TrimPrefix(path.Ext(a.sourceOf(namespace).Namespace), ".")
+	if format == "" {
+		return "properties"
+	}
+	return format
+}
+
+// expandContent Replace the content of a file-style namespace by its keys.
+//
+// Apollo serves properties namespaces, e.g. application, as flat key/values,
+// while namespaces in other formats are named after their format, e.g.
+// db.yaml, and served as their raw text under the content key. The content of
+// such namespaces is parsed with the viper config type of their format, so
+// that its keys are merged like those of properties namespaces. Content viper
+// can't parse, e.g. of a .txt or .xml namespace, is kept as is under the name
+// of the namespace.
+func expandContent(namespace, format string, cfg map[string]interface{}) (map[string]interface{}, error) {
+	if format == "properties" {
+		return cfg, nil
+	}
+	content, ok := cfg[contentKey].(string)
+	if !ok {
+		return cfg, nil
+	}
+	if !stringInSlice(format, viper.SupportedExts) {
+		return map[string]interface{}{namespace: content}, nil
+	}
+
+	v := viper.New()
+	v.SetConfigType(format)
+	if err := v.ReadConfig(strings.NewReader(content)); err != nil {
+		return nil, fmt.Errorf("failed parsing content of namespace %s: %w", namespace, err)
+	}
+	return v.AllSettings(), nil
+}
+
+// RawFile returns the content of namespace as a file, as served by the
+// configfiles endpoint of apollo: the raw text of namespaces in other formats
+// than properties, e.g. a whole db.yaml to feed to a parser of its own, and
+// properties namespaces as key=value lines. It is read from apollo on each
+// call, bypassing the disk cache.
+func (a *Apollo) RawFile(namespace string) ([]byte, error) {
+	resp, b, err := a.fetch(a.baseCtx, a.namespacePath("configfiles", namespace))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return nil, fmt.Errorf("failed reading apollo file %s: status %d", namespace, resp.StatusCode)
+	}
+	return b, nil
+}
+
+// stringInSlice Report whether s is in list
+func stringInSlice(s string, list []string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRawFile(t *testing.T) {
+	content := "logging:\n  level: debug\n"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/configfiles/app/default/app.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), NamespaceName("app.yaml"))
+	b, err := a.RawFile("app.yaml")
+	if err != nil {
+		t.Fatalf("RawFile: %v", err)
+	}
+	if string(b) != content {
+		t.Fatalf("expected %q, got %q", content, b)
+	}
+	if _, err := a.RawFile("missing.yaml"); err == nil {
+		t.Fatalf("expected an error for a missing namespace")
+	}
+}
+
+func TestNamespaceFormat(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), Namespaces("app.txt", "db.yaml"),
+		NamespaceFormat("app.txt", "json"), WithLogger(discard))
+	cfg, err := a.configurations("app.txt", []byte(`{"content":"{\"port\":8080}"}`))
+	if err != nil {
+		t.Fatalf("configurations: %v", err)
+	}
+	if cfg["port"] != float64(8080) {
+		t.Fatalf("expected app.txt parsed as json, got %#v", cfg)
+	}
+	cfg, err = a.configurations("db.yaml", []byte(`{"content":"host: db\n"}`))
+	if err != nil {
+		t.Fatalf("configurations: %v", err)
+	}
+	if cfg["host"] != "db" {
+		t.Fatalf("expected db.yaml parsed by its suffix, got %#v", cfg)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// StrictDecoding makes ParseStruct report remote keys matching no field of
+// the struct interface, and return a *DecodeError listing the errors of each
+// field instead of a single error. Local settings layered into the remote
+// viper, e.g. env, aren't reported.
+func StrictDecoding() Option {
+	return optionFunc(func(a *Apollo) {
+		a.strictDecoding = true
+	})
+}
+
+// OnDecodeError replaces the logging of errors decoding settings into the
+// struct interface and subtree bindings with fn, e.g. to count a metric or
+// panic. phase is "local" or "remote" for the settings decoded by
+// ParseStruct, and "subtree:" followed by the prefix for subtree bindings. A
+// struct whose remote settings failed to decode is left unchanged whatever fn
+// does.
+func OnDecodeError(fn func(phase string, err error)) Option {
+	return optionFunc(func(a *Apollo) {
+		a.onDecodeError = fn
+	})
+}
+
+// decodeError Report an error decoding settings of phase, logged unless
+// OnDecodeError is set
+func (a *Apollo) decodeError(phase string, err error) {
+	a.startupError(fmt.Errorf("decoding %s settings: %w", phase, err))
+	if a.onDecodeError != nil {
+		a.onDecodeError(phase, err)
+		return
+	}
+	switch phase {
+	case "local":
+		a.logger.Printf("Read LOCAL config with error=%v", err)
+	case "remote":
+		a.logger.Printf("Read REMOTE config with error=%v, keeping previous struct", err)
+	default:
+		a.logger.Printf("Bind %s with error=%v", strings.Replace(phase, ":", " ", 1), err)
+	}
+}
+
+// DecodeTimeout bounds the time ParseStruct may spend decoding settings, e.g.
+// against a degenerate configuration stalling custom decode hooks. A decode
+// exceeding timeout fails and the previous struct is kept. The stalled decode
+// can't be interrupted and runs to completion in the background, on a copy of
+// the struct which is then discarded.
+func DecodeTimeout(timeout time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.decodeTimeout = timeout
+	})
+}
+
+// decodeStructWithin Decode settings into result as decodeStruct does, failing
+// if it takes longer than the decode timeout
+func (a *Apollo) decodeStructWithin(result interface{}, local, remote map[string]interface{}) error {
+	if a.decodeTimeout <= 0 {
+		return a.decodeStruct(result, local, remote)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- a.decodeStruct(result, local, remote)
+	}()
+	timer := time.NewTimer(a.decodeTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		err := fmt.Errorf("decoding struct took longer than %v", a.decodeTimeout)
+		a.decodeError("remote", err)
+		return err
+	}
+}
+
+// decodeStruct Decode local and remote settings into result in the order of
+// precedence, report the error decoding remote settings
+func (a *Apollo) decodeStruct(result interface{}, local, remote map[string]interface{}) error {
+	deCfg := &mapstructure.DecoderConfig{
+		DecodeHook: a.structHook(),
+		Result:     result,
+	}
+	d, _ := mapstructure.NewDecoder(deCfg)
+	decodeLocal := func() {
+		if local != nil {
+			err := d.Decode(local)
+			if err != nil {
+				a.decodeError("local", err)
+			}
+		}
+	}
+	if !a.localFirst {
+		decodeLocal()
+	}
+	remoteDecoder := d
+	if a.strictDecoding {
+		strictCfg := *deCfg
+		strictCfg.ErrorUnused = true
+		remoteDecoder, _ = mapstructure.NewDecoder(&strictCfg)
+	}
+	err := remoteDecoder.Decode(remote)
+	if err != nil && a.strictDecoding {
+		err = a.dropLocalUnused(newDecodeError(err))
+	}
+	if err != nil {
+		a.decodeError("remote", err)
+		return err
+	}
+	if a.localFirst {
+		decodeLocal()
+	}
+	return nil
+}
+
+// FieldError describes why a field of the struct interface couldn't be
+// decoded. Expected, Actual and Value are empty when the decoder didn't report
+// them.
+type FieldError struct {
+	// Field is the path of the field, e.g. "db.port"
+	Field string
+	// Expected is the type of the field
+	Expected string
+	// Actual is the type of the value
+	Actual string
+	// Value is the value which couldn't be decoded
+	Value string
+	// Message is the error reported by the decoder
+	Message string
+	// unused is set when the field is a key matching no field
+	unused bool
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// DecodeError is returned by ParseStruct with StrictDecoding when fields of
+// the struct interface couldn't be decoded
+type DecodeError struct {
+	fields []FieldError
+}
+
+func (e *DecodeError) Error() string {
+	messages := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		messages[i] = f.Message
+	}
+	return fmt.Sprintf("failed decoding %d field(s): %s", len(e.fields), strings.Join(messages, "; "))
+}
+
+// Fields returns the errors of each field
+func (e *DecodeError) Fields() []FieldError {
+	return append([]FieldError(nil), e.fields...)
+}
+
+var (
+	unconvertiblePattern = regexp.MustCompile(`^'(.*)' expected type '(.*)', got unconvertible type '(.*)', value: '(.*)'$`)
+	expectedPattern      = regexp.MustCompile(`^'(.*)' expected type '(.*)', got '(.*)'$`)
+	parsePattern         = regexp.MustCompile(`^cannot parse '(.*?)' as (\w+): `)
+	invalidKeysPattern   = regexp.MustCompile(`^'(.*?)' has invalid keys: (.*)$`)
+	quotedPattern        = regexp.MustCompile(`'([^']*)'`)
+)
+
+// newDecodeError Turn the error of mapstructure, a list of messages, into
+// field errors
+func newDecodeError(err error) error {
+	merr, ok := err.(*mapstructure.Error)
+	if !ok {
+		return err
+	}
+	e := &DecodeError{}
+	for _, msg := range merr.Errors {
+		e.fields = append(e.fields, parseFieldErrors(msg)...)
+	}
+	return e
+}
+
+// dropLocalUnused Drop from err the keys matching no field which are local
+// settings layered into the remote viper, as StrictDecoding only checks keys
+// read from apollo. nil is returned if no field error is left.
+func (a *Apollo) dropLocalUnused(err error) error {
+	e, ok := err.(*DecodeError)
+	if !ok {
+		return err
+	}
+	fields := e.fields[:0]
+	for _, f := range e.fields {
+		if !f.unused || !a.localOnly(f.Field) {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &DecodeError{fields: fields}
+}
+
+// parseFieldErrors Parse a message of mapstructure into field errors
+func parseFieldErrors(msg string) []FieldError {
+	if m := unconvertiblePattern.FindStringSubmatch(msg); m != nil {
+		return []FieldError{{Field: m[1], Expected: m[2], Actual: m[3], Value: m[4], Message: msg}}
+	}
+	if m := expectedPattern.FindStringSubmatch(msg); m != nil {
+		return []FieldError{{Field: m[1], Expected: m[2], Actual: m[3], Message: msg}}
+	}
+	if m := parsePattern.FindStringSubmatch(msg); m != nil {
+		return []FieldError{{Field: m[1], Expected: m[2], Message: msg}}
+	}
+	if m := invalidKeysPattern.FindStringSubmatch(msg); m != nil {
+		var fields []FieldError
+		for _, key := range strings.Split(m[2], ", ") {
+			field := key
+			if m[1] != "" {
+				field = m[1] + "." + key
+			}
+			fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf("'%s' matches no field", field), unused: true})
+		}
+		return fields
+	}
+	field := ""
+	if m := quotedPattern.FindStringSubmatch(msg); m != nil {
+		field = m[1]
+	}
+	return []FieldError{{Field: field, Message: msg}}
+}
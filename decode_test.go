@@ -0,0 +1,201 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestStrictDecodingFieldErrors(t *testing.T) {
+	type config struct {
+		Port    int  `mapstructure:"port"`
+		Enabled bool `mapstructure:"enabled"`
+		DB      struct {
+			Port int `mapstructure:"port"`
+		} `mapstructure:"db"`
+	}
+	cfg := config{}
+	a := InitApollo(Server("http://localhost"), AppId("app"), Struct(&cfg), StrictDecoding(), WithLogger(discard))
+	err := a.ParseStruct(nil, map[string]interface{}{
+		"port":    "abc",
+		"enabled": []interface{}{"x"},
+		"db":      map[string]interface{}{"port": 5432, "user": "app"},
+		"unknown": "1",
+	})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a *DecodeError, got %T: %v", err, err)
+	}
+
+	fields := map[string]FieldError{}
+	var names []string
+	for _, f := range decodeErr.Fields() {
+		fields[f.Field] = f
+		names = append(names, f.Field)
+	}
+	sort.Strings(names)
+	want := []string{"db.user", "enabled", "port", "unknown"}
+	if len(names) != len(want) {
+		t.Fatalf("expected field errors for %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected field errors for %v, got %v", want, names)
+		}
+	}
+	if f := fields["port"]; f.Expected != "int" || f.Actual != "string" || f.Value != "abc" {
+		t.Fatalf("unexpected port error %+v", f)
+	}
+	if f := fields["enabled"]; f.Expected != "bool" {
+		t.Fatalf("unexpected enabled error %+v", f)
+	}
+}
+
+func TestDecodeErrorWithoutStrictDecoding(t *testing.T) {
+	type config struct {
+		Port int `mapstructure:"port"`
+	}
+	cfg := config{}
+	a := InitApollo(Server("http://localhost"), AppId("app"), Struct(&cfg), WithLogger(discard))
+	err := a.ParseStruct(nil, map[string]interface{}{"port": "abc", "unknown": "1"})
+	var decodeErr *DecodeError
+	if err == nil || errors.As(err, &decodeErr) {
+		t.Fatalf("expected a plain decode error, got %v", err)
+	}
+}
+
+func TestOnDecodeError(t *testing.T) {
+	var cfg struct {
+		Port int `mapstructure:"port"`
+	}
+	var phases []string
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), Struct(&cfg),
+		OnDecodeError(func(phase string, err error) {
+			phases = append(phases, phase)
+		}))
+	cfg.Port = 80
+	err := a.ParseStruct(map[string]interface{}{"port": []int{1}}, map[string]interface{}{"port": "abc"})
+	if err == nil {
+		t.Fatalf("expected a decode error")
+	}
+	if strings.Join(phases, ",") != "local,remote" {
+		t.Fatalf("expected local and remote errors reported, got %v", phases)
+	}
+	if cfg.Port != 80 {
+		t.Fatalf("expected the previous struct kept, got port %d", cfg.Port)
+	}
+}
+
+// slowCodec delays unmarshaling, as a degenerate configuration stalling the
+// decode of the struct would
+type slowCodec struct {
+	stdCodec
+	delay time.Duration
+}
+
+func (c slowCodec) Unmarshal(data []byte, v interface{}) error {
+	time.Sleep(c.delay)
+	return c.stdCodec.Unmarshal(data, v)
+}
+
+func TestDecodeTimeout(t *testing.T) {
+	type config struct {
+		Port int `mapstructure:"port"`
+		DB   struct {
+			Host string `mapstructure:"host"`
+		} `mapstructure:"db"`
+	}
+	cfg := config{Port: 80}
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), Struct(&cfg), WithLogger(discard),
+		WithJSONCodec(slowCodec{delay: 200 * time.Millisecond}), DecodeTimeout(20*time.Millisecond))
+	err := a.ParseStruct(nil, map[string]interface{}{"port": "8080", "db": `{"host":"db"}`})
+	if err == nil {
+		t.Fatalf("expected the decode to time out")
+	}
+	if cfg.Port != 80 {
+		t.Fatalf("expected the previous struct kept, got port %d", cfg.Port)
+	}
+
+	a.decodeTimeout = time.Second
+	if err := a.ParseStruct(nil, map[string]interface{}{"port": "8080", "db": `{"host":"db"}`}); err != nil {
+		t.Fatalf("ParseStruct: %v", err)
+	}
+	if cfg.Port != 8080 || cfg.DB.Host != "db" {
+		t.Fatalf("unexpected struct %+v", cfg)
+	}
+}
+
+func TestParseStructJSONArray(t *testing.T) {
+	type Upstream struct {
+		Host   string `mapstructure:"host"`
+		Weight int    `mapstructure:"weight"`
+	}
+	var cfg struct {
+		Upstreams []Upstream `mapstructure:"upstreams"`
+		Tags      []string   `mapstructure:"tags"`
+	}
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), Struct(&cfg), WithLogger(discard))
+	err := a.ParseStruct(nil, map[string]interface{}{
+		"upstreams": `[{"host":"a","weight":1},{"host":"b","weight":2}]`,
+		"tags":      ` ["x","y"]`,
+	})
+	if err != nil {
+		t.Fatalf("ParseStruct: %v", err)
+	}
+	if len(cfg.Upstreams) != 2 || cfg.Upstreams[1].Host != "b" || cfg.Upstreams[1].Weight != 2 {
+		t.Fatalf("unexpected upstreams %+v", cfg.Upstreams)
+	}
+	if len(cfg.Tags) != 2 || cfg.Tags[0] != "x" {
+		t.Fatalf("unexpected tags %v", cfg.Tags)
+	}
+}
+
+func TestStrictDecodingIgnoresLocalKeys(t *testing.T) {
+	viper.Set("env", "dev")
+	viper.Set("port", 8080)
+	defer viper.Reset()
+
+	type config struct {
+		Port int `mapstructure:"port"`
+	}
+	for _, tc := range []struct {
+		served string
+		unused []string
+	}{
+		{`{"port":"80"}`, nil},
+		{`{"port":"80","unknown":"1"}`, []string{"unknown"}},
+	} {
+		ts := newFakeApollo(tc.served)
+		cfg := config{}
+		a := InitApollo(Server(ts.URL), AppId("app"), Struct(&cfg), StrictDecoding(), WithLogger(discard))
+		if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+			t.Fatalf("InitViperRemote: %v", err)
+		}
+		stopWatch(a)
+		ts.Close()
+
+		err := a.ParseStruct(nil, Remote.AllSettings())
+		if tc.unused == nil {
+			if err != nil || cfg.Port != 80 {
+				t.Fatalf("%s: expected local keys ignored, got port %d, err %v", tc.served, cfg.Port, err)
+			}
+			continue
+		}
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("%s: expected a *DecodeError, got %v", tc.served, err)
+		}
+		if fields := decodeErr.Fields(); len(fields) != 1 || fields[0].Field != tc.unused[0] {
+			t.Fatalf("%s: expected only %v reported, got %+v", tc.served, tc.unused, fields)
+		}
+	}
+}
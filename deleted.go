@@ -0,0 +1,115 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// DeletedKeyPolicy controls what becomes of keys deleted in apollo on reload
+type DeletedKeyPolicy int
+
+const (
+	// Remove removes deleted keys from the remote viper and resets the
+	// struct fields decoded from them to their zero value
+	Remove DeletedKeyPolicy = iota
+	// Retain keeps the last value of deleted keys
+	Retain
+	// RevertToDefault removes deleted keys from the remote viper and reverts
+	// the struct fields decoded from them to the value they had before any
+	// configuration was decoded
+	RevertToDefault
+)
+
+// DeletedKeys sets what becomes of keys deleted in apollo on reload, Remove by
+// default
+func DeletedKeys(policy DeletedKeyPolicy) Option {
+	return optionFunc(func(a *Apollo) {
+		a.deletedKeyPolicy = policy
+	})
+}
+
+// withDeletedKeys Add the keys served to viper by the previous read and
+// missing from cfg as null, so that viper drops them instead of merging cfg
+// over them, and record them for the struct interface
+func (a *Apollo) withDeletedKeys(cfg map[string]interface{}) map[string]interface{} {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	served := a.servedKeys
+	a.servedKeys = make(map[string]bool, len(cfg))
+	for k := range cfg {
+		a.servedKeys[k] = true
+	}
+	a.deletedKeys = nil
+	if a.deletedKeyPolicy == Retain {
+		return cfg
+	}
+
+	out := cfg
+	for k := range served {
+		if _, ok := cfg[k]; ok {
+			continue
+		}
+		if len(a.deletedKeys) == 0 {
+			out = make(map[string]interface{}, len(cfg)+len(served))
+			for k, v := range cfg {
+				out[k] = v
+			}
+		}
+		out[k] = nil
+		a.deletedKeys = append(a.deletedKeys, k)
+	}
+	return out
+}
+
+// takeDeletedKeys Return the keys deleted by the last read once
+func (a *Apollo) takeDeletedKeys() []string {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	deleted := a.deletedKeys
+	a.deletedKeys = nil
+	return deleted
+}
+
+// resetDeletedFields Reset the fields of the struct interface decoded from
+// deleted keys according to the deleted key policy
+func (a *Apollo) resetDeletedFields(deleted []string) error {
+	values := make(map[string]interface{}, len(deleted))
+	for _, k := range deleted {
+		values[k] = nil
+		if a.deletedKeyPolicy == RevertToDefault {
+			values[k] = a.structDefaults[strings.ToLower(k)]
+		}
+	}
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: a.structHook(),
+		ZeroFields: true,
+		Result:     a.object,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Decode(values)
+}
+
+// snapshotStructDefaults Keep the values of the struct interface before any
+// configuration is decoded, keyed by lower case field name
+func (a *Apollo) snapshotStructDefaults() {
+	if a.object == nil {
+		return
+	}
+	defaults := map[string]interface{}{}
+	if err := mapstructure.Decode(a.object, &defaults); err != nil {
+		a.logger.Printf("Failed reading struct defaults: %v", err)
+		return
+	}
+	a.structDefaults = make(map[string]interface{}, len(defaults))
+	for k, v := range defaults {
+		a.structDefaults[strings.ToLower(k)] = v
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDeletedKeyPolicies(t *testing.T) {
+	type config struct {
+		Port    int    `mapstructure:"port"`
+		Timeout string `mapstructure:"timeout"`
+	}
+	for _, tc := range []struct {
+		policy  DeletedKeyPolicy
+		set     bool
+		timeout string
+	}{
+		{Remove, false, ""},
+		{Retain, true, "5s"},
+		{RevertToDefault, false, "1s"},
+	} {
+		var configurations atomic.Value
+		configurations.Store(`{"port":"80","timeout":"5s"}`)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"appId":"app","configurations":` + configurations.Load().(string) + `,"releaseKey":"r1"}`))
+		}))
+
+		cfg := config{Timeout: "1s"}
+		a := InitApollo(Server(ts.URL), AppId("app"), Struct(&cfg), DeletedKeys(tc.policy), WithLogger(discard))
+		useRemote(t, a)
+		a.applyChange()
+		if cfg.Timeout != "5s" || Remote.GetString("timeout") != "5s" {
+			t.Fatalf("policy %d: expected timeout 5s loaded, got %+v", tc.policy, cfg)
+		}
+
+		configurations.Store(`{"port":"81"}`)
+		a.applyChange()
+		if cfg.Port != 81 {
+			t.Fatalf("policy %d: expected port 81, got %d", tc.policy, cfg.Port)
+		}
+		if got := Remote.IsSet("timeout"); got != tc.set {
+			t.Fatalf("policy %d: expected timeout set=%v in viper, got %v", tc.policy, tc.set, got)
+		}
+		if cfg.Timeout != tc.timeout {
+			t.Fatalf("policy %d: expected struct timeout %q, got %q", tc.policy, tc.timeout, cfg.Timeout)
+		}
+		ts.Close()
+	}
+	viper.Reset()
+}
@@ -0,0 +1,55 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParseDSN parses apollo coordinates given as a single string into the
+// equivalent options, e.g. from a flag or an environment variable:
+//
+//	apollo://appId@host:port/cluster/namespace?key=secret
+//
+// appId and host are mandatory. cluster and namespace are optional, several
+// namespaces may be separated with commas. key sets the access key.
+func ParseDSN(dsn string) ([]Option, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apollo dsn: %w", err)
+	}
+	if u.Scheme != "apollo" {
+		return nil, fmt.Errorf("invalid apollo dsn: unsupported scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("invalid apollo dsn: missing appId")
+	}
+	if u.Host == "" {
+		return nil, errors.New("invalid apollo dsn: missing host")
+	}
+
+	opts := []Option{
+		AppId(u.User.Username()),
+		Server("http://" + u.Host),
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) > 2 {
+		return nil, fmt.Errorf("invalid apollo dsn: unexpected path %q", u.Path)
+	}
+	if len(segments) > 0 && segments[0] != "" {
+		opts = append(opts, Cluster(segments[0]))
+	}
+	if len(segments) > 1 && segments[1] != "" {
+		opts = append(opts, Namespaces(strings.Split(segments[1], ",")...))
+	}
+	if key := u.Query().Get("key"); key != "" {
+		opts = append(opts, AccessKey(key))
+	}
+	return opts, nil
+}
@@ -0,0 +1,45 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import "testing"
+
+func TestParseDSN(t *testing.T) {
+	opts, err := ParseDSN("apollo://app@config.local:8080/prod/application,shared?key=s3cr3t")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	a := InitApollo(opts...)
+	if a.appID != "app" || a.server != "http://config.local:8080" || a.cluster != "prod" {
+		t.Fatalf("unexpected coordinates appId=%s server=%s cluster=%s", a.appID, a.server, a.cluster)
+	}
+	if len(a.namespaces) != 2 || a.namespaces[0] != "application" || a.namespaces[1] != "shared" {
+		t.Fatalf("unexpected namespaces %v", a.namespaces)
+	}
+	if a.accessKey == nil {
+		t.Fatal("expected access key to be set")
+	}
+
+	opts, err = ParseDSN("apollo://app@config.local:8080")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	a = InitApollo(opts...)
+	if a.cluster != "default" || a.namespaceName != "application" || a.accessKey != nil {
+		t.Fatalf("expected defaults, got cluster=%s namespace=%s", a.cluster, a.namespaceName)
+	}
+
+	for _, dsn := range []string{
+		"http://app@config.local:8080",
+		"apollo://config.local:8080",
+		"apollo://app@/prod",
+		"apollo://app@config.local/prod/application/extra",
+	} {
+		if _, err := ParseDSN(dsn); err == nil {
+			t.Fatalf("expected %q to be rejected", dsn)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LogEffectiveConfig logs the configuration in effect once at startup,
+// encoded in format as EffectiveConfig does with the keys of RedactPattern
+// redacted, so that what a release resolved to can be audited and diffed
+// across deploys.
+func LogEffectiveConfig(format string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.effectiveFormat = format
+	})
+}
+
+// EffectiveConfig returns the configuration in effect, as the remote viper
+// holds it with local settings layered, encoded in format: json, yaml or
+// properties. Values of keys containing any of redactKeys, ignoring case, are
+// redacted, those of keys matching RedactPattern if redactKeys is empty.
+func (a *Apollo) EffectiveConfig(format string, redactKeys []string) ([]byte, error) {
+	pattern := a.redactPattern
+	if pattern == nil {
+		pattern = defaultRedactPattern
+	}
+	if len(redactKeys) > 0 {
+		quoted := make([]string, len(redactKeys))
+		for i, k := range redactKeys {
+			quoted[i] = regexp.QuoteMeta(k)
+		}
+		pattern = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	}
+	settings := a.configCopy()
+	if Remote != nil {
+		settings = Remote.AllSettings()
+	}
+	settings = redact(settings, pattern)
+
+	switch format {
+	case "json":
+		return json.MarshalIndent(settings, "", "  ")
+	case "yaml", "yml":
+		return yaml.Marshal(settings)
+	case "properties":
+		var b strings.Builder
+		writeProperties(&b, "", settings)
+		return []byte(b.String()), nil
+	}
+	return nil, fmt.Errorf("unsupported effective config format %q", format)
+}
+
+// logEffectiveConfig Log the configuration in effect if LogEffectiveConfig
+// is set
+func (a *Apollo) logEffectiveConfig() {
+	if a.effectiveFormat == "" {
+		return
+	}
+	b, err := a.EffectiveConfig(a.effectiveFormat, nil)
+	if err != nil {
+		a.logger.Printf("Failed encoding effective config: %v", err)
+		return
+	}
+	a.logger.Printf("Effective config:\n%s", b)
+}
+
+// propertiesEscaper escapes line breaks of properties values
+var propertiesEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// writeProperties Write settings as sorted key = value lines, nested keys
+// joined by dots under prefix
+func writeProperties(b *strings.Builder, prefix string, settings map[string]interface{}) {
+	for _, k := range sortedKeys(settings) {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := settings[k].(map[string]interface{}); ok {
+			writeProperties(b, key, nested)
+			continue
+		}
+		fmt.Fprintf(b, "%s = %s\n", key, propertiesEscaper.Replace(fmt.Sprint(settings[k])))
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	ts := newFakeApollo(`{"port":"8080","db_password":"hunter2","motd":"a\nb"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	useRemote(t, a)
+	if err := Remote.ReadRemoteConfig(); err != nil {
+		t.Fatalf("ReadRemoteConfig: %v", err)
+	}
+
+	b, err := a.EffectiveConfig("properties", nil)
+	if err != nil {
+		t.Fatalf("EffectiveConfig: %v", err)
+	}
+	if want := "db_password = ******\nmotd = a\\nb\nport = 8080\n"; string(b) != want {
+		t.Fatalf("expected %q, got %q", want, b)
+	}
+
+	b, err = a.EffectiveConfig("yaml", []string{"PORT"})
+	if err != nil {
+		t.Fatalf("EffectiveConfig: %v", err)
+	}
+	if !strings.Contains(string(b), "port: '******'") || !strings.Contains(string(b), "hunter2") {
+		t.Fatalf("expected only the given keys redacted, got %s", b)
+	}
+
+	if b, err = a.EffectiveConfig("json", nil); err != nil || !strings.Contains(string(b), `"port": "8080"`) {
+		t.Fatalf("unexpected json %s, %v", b, err)
+	}
+	if _, err := a.EffectiveConfig("toml", nil); err == nil {
+		t.Fatal("expected an unsupported format to fail")
+	}
+}
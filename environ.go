@@ -0,0 +1,76 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvironPrefix sets the prefix of variable names returned by Environ, e.g.
+// "APP_"
+func EnvironPrefix(prefix string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.environPrefix = prefix
+	})
+}
+
+// EnvironKeyTransform replaces how Environ turns keys into variable names,
+// upper snake case by default, e.g. "db.maxConns" becomes "DB_MAXCONNS". The
+// prefix is added to the transformed name.
+func EnvironKeyTransform(transform func(key string) string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.environTransform = transform
+	})
+}
+
+// Environ returns the configuration last loaded from apollo as sorted
+// KEY=value pairs, e.g. to pass it to a child process with exec.Cmd.Env.
+// Nested keys are flattened with dots before being transformed.
+func (a *Apollo) Environ() []string {
+	_ = a.Load()
+	flat := map[string]interface{}{}
+	flatten("", a.configCopy(), flat)
+
+	transform := a.environTransform
+	if transform == nil {
+		transform = upperSnake
+	}
+	env := make([]string, 0, len(flat))
+	for _, k := range sortedKeys(flat) {
+		env = append(env, a.environPrefix+transform(k)+"="+fmt.Sprint(flat[k]))
+	}
+	return env
+}
+
+// flatten Copy the keys of cfg into flat, keys of nested maps joined with dots
+func flatten(prefix string, cfg map[string]interface{}, flat map[string]interface{}) {
+	for k, v := range cfg {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(k, nested, flat)
+			continue
+		}
+		flat[k] = v
+	}
+}
+
+// upperSnake Turn key into an environment variable name, replacing anything
+// but letters and digits with underscores
+func upperSnake(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
@@ -0,0 +1,31 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnviron(t *testing.T) {
+	a := InitApollo(Server("http://localhost"), AppId("app"), EnvironPrefix("APP_"))
+	a.setConfig(map[string]interface{}{
+		"db.maxConns": "10",
+		"log-level":   "info",
+		"cache":       map[string]interface{}{"ttl": 30},
+	}, SourceRemote)
+	want := []string{"APP_CACHE_TTL=30", "APP_DB_MAXCONNS=10", "APP_LOG_LEVEL=info"}
+	if got := a.Environ(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	a = InitApollo(Server("http://localhost"), AppId("app"), EnvironKeyTransform(strings.ToLower))
+	a.setConfig(map[string]interface{}{"db.host": "localhost"}, SourceRemote)
+	if got := a.Environ(); !reflect.DeepEqual(got, []string{"db.host=localhost"}) {
+		t.Fatalf("expected transformed key, got %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// ErrResponseTooLarge is returned when an apollo response exceeds the size
+// set by MaxResponseBytes
+var ErrResponseTooLarge = errors.New("apollo response too large")
+
+// ErrMissingArguments is raised at init when the server or the app id is
+// missing
+var ErrMissingArguments = errors.New("missing arguments(server, appId)")
+
+// ErrTooManyNamespaces is raised at init when more namespaces than
+// MaxNamespaces allows are loaded
+var ErrTooManyNamespaces = errors.New("too many apollo namespaces")
+
+// ErrLocalFileNotFound is returned by Init when the local file is missing
+var ErrLocalFileNotFound = errors.New("local config file not found")
+
+// ErrLocalFileParse is returned by Init when the local file can't be parsed
+var ErrLocalFileParse = errors.New("failed parsing local config file")
+
+// localFileError Describe the error of reading the local file at path, wrapping
+// ErrLocalFileNotFound or ErrLocalFileParse when it is one of those, and the
+// original error otherwise, e.g. os.ErrPermission
+func localFileError(path string, err error) error {
+	var notFound viper.ConfigFileNotFoundError
+	var parse viper.ConfigParseError
+	switch {
+	case errors.As(err, &notFound) || errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w at %s", ErrLocalFileNotFound, path)
+	case errors.As(err, &parse):
+		return fmt.Errorf("%w %s: %v", ErrLocalFileParse, path, err)
+	}
+	return fmt.Errorf("failed reading local config file %s: %w", path, err)
+}
+
+// fetchError is the error of reading configuration from apollo, as opposed
+// to configuration rejected once read
+type fetchError struct {
+	err error
+}
+
+func (e *fetchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+// isFetchError Report whether err failed reading configuration from apollo
+func isFetchError(err error) bool {
+	var fe *fetchError
+	return errors.As(err, &fe)
+}
+
+// invalidResponseBodySize is the number of body bytes kept in an
+// ErrInvalidResponse
+const invalidResponseBodySize = 128
+
+// ErrInvalidResponse is returned when apollo answers with a body which can't
+// be decoded, e.g. an HTML error page of a broken proxy in front of apollo
+type ErrInvalidResponse struct {
+	StatusCode  int
+	ContentType string
+	// Body holds the first bytes of the response body
+	Body []byte
+	Err  error
+}
+
+func newInvalidResponse(resp *http.Response, body []byte, err error) *ErrInvalidResponse {
+	if len(body) > invalidResponseBodySize {
+		body = body[:invalidResponseBodySize]
+	}
+	return &ErrInvalidResponse{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+		Err:         err,
+	}
+}
+
+func (e *ErrInvalidResponse) Error() string {
+	return fmt.Sprintf("invalid apollo response(status=%d, content-type=%q): %v, body=%q",
+		e.StatusCode, e.ContentType, e.Err, e.Body)
+}
+
+func (e *ErrInvalidResponse) Unwrap() error {
+	return e.Err
+}
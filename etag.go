@@ -0,0 +1,78 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// validatedResponse is a configuration response kept to answer conditional
+// requests, with the validators apollo or a proxy in front of it served
+type validatedResponse struct {
+	etag         string
+	lastModified string
+	body         []byte
+	// decoded is body decoded, nil until a caller decoded it
+	decoded *apolloResponse
+}
+
+// conditionalHeader Return the headers making the request for path
+// conditional on the last response received for it, nil if none was validated
+func (a *Apollo) conditionalHeader(path string) http.Header {
+	a.validatedMu.Lock()
+	defer a.validatedMu.Unlock()
+	v, ok := a.validated[path]
+	if !ok {
+		return nil
+	}
+	header := http.Header{}
+	if v.etag != "" {
+		header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		header.Set("If-Modified-Since", v.lastModified)
+	}
+	return header
+}
+
+// validatedResponse Return a copy of the last response received for path,
+// false if it didn't carry an ETag or Last-Modified header
+func (a *Apollo) validatedResponse(path string) (validatedResponse, bool) {
+	a.validatedMu.Lock()
+	defer a.validatedMu.Unlock()
+	v, ok := a.validated[path]
+	if !ok {
+		return validatedResponse{}, false
+	}
+	return *v, true
+}
+
+// keepResponse Keep body to answer the next conditional request for path if
+// resp carries validators, forgetting the previous response otherwise
+func (a *Apollo) keepResponse(path string, resp *http.Response, body []byte) {
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	a.validatedMu.Lock()
+	defer a.validatedMu.Unlock()
+	if etag == "" && lastModified == "" {
+		delete(a.validated, path)
+		return
+	}
+	if a.validated == nil {
+		a.validated = map[string]*validatedResponse{}
+	}
+	a.validated[path] = &validatedResponse{etag: etag, lastModified: lastModified, body: body}
+}
+
+// keepDecoded Keep the decoded response for path, so that it isn't decoded
+// again when apollo answers it is not modified
+func (a *Apollo) keepDecoded(path string, body []byte, decoded apolloResponse) {
+	a.validatedMu.Lock()
+	defer a.validatedMu.Unlock()
+	if v, ok := a.validated[path]; ok && bytes.Equal(v.body, body) {
+		v.decoded = &decoded
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	var conditional int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"r1"`)
+		if r.Header.Get("If-None-Match") == `"r1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"},"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"))
+	for i := 0; i < 2; i++ {
+		if _, err := a.load(); err != nil {
+			t.Fatalf("load %d: %v", i, err)
+		}
+		if a.config["a"] != "1" {
+			t.Fatalf("load %d: unexpected config %v", i, a.config)
+		}
+	}
+	if conditional != 1 {
+		t.Fatalf("expected the second request to be conditional, got %d conditional requests", conditional)
+	}
+}
+
+func TestNoConditionalRequestWithoutValidators(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			t.Errorf("unexpected conditional request")
+		}
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"))
+	for i := 0; i < 2; i++ {
+		if _, err := a.load(); err != nil {
+			t.Fatalf("load %d: %v", i, err)
+		}
+	}
+}
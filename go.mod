@@ -6,4 +6,6 @@ require (
 	github.com/mitchellh/mapstructure v1.4.3
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.10.1
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
+	gopkg.in/yaml.v2 v2.4.0
 )
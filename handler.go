@@ -0,0 +1,107 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// defaultRedactPattern matches the keys whose values are redacted by default
+var defaultRedactPattern = regexp.MustCompile(`(?i)password|passwd|secret|token|credential|private`)
+
+// redacted replaces the values of redacted keys
+const redacted = "******"
+
+// RedactPattern sets the pattern of keys whose values Handler and
+// EffectiveConfig redact, keys containing password, secret, token, credential
+// or private by default
+func RedactPattern(pattern *regexp.Regexp) Option {
+	return optionFunc(func(a *Apollo) {
+		a.redactPattern = pattern
+	})
+}
+
+// handlerState is the document served by Handler
+type handlerState struct {
+	Config      map[string]interface{} `json:"config"`
+	Source      ConfigSource           `json:"source"`
+	ReleaseKeys map[string]string      `json:"releaseKeys"`
+	LastFetch   time.Time              `json:"lastFetch"`
+	Watching    bool                   `json:"watching"`
+	Degraded    bool                   `json:"degraded"`
+	Stats       WatchStats             `json:"stats"`
+}
+
+// Handler returns a read-only handler serving the current configuration as
+// json, with values of keys matching RedactPattern redacted, along with the
+// release key of each namespace, when configuration was last read and the
+// health of the watcher. It is meant for debugging, e.g.
+//
+//	http.Handle("/debug/apollo", apollo.Handler())
+func (a *Apollo) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := json.MarshalIndent(a.handlerState(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	})
+}
+
+// handlerState Describe the current configuration and the watcher
+func (a *Apollo) handlerState() handlerState {
+	pattern := a.redactPattern
+	if pattern == nil {
+		pattern = defaultRedactPattern
+	}
+	releaseKeys := map[string]string{}
+	for _, namespace := range a.namespaces {
+		releaseKeys[namespace] = a.releaseKey(namespace)
+	}
+	a.applyMu.Lock()
+	lastFetch := a.lastApply
+	a.applyMu.Unlock()
+	a.watchMu.Lock()
+	watching := a.quit != nil
+	a.watchMu.Unlock()
+
+	return handlerState{
+		Config:      redact(a.configCopy(), pattern),
+		Source:      a.Source(),
+		ReleaseKeys: releaseKeys,
+		LastFetch:   lastFetch,
+		Watching:    watching,
+		Degraded:    a.Degraded(),
+		Stats:       a.Stats(),
+	}
+}
+
+// redact Return a copy of cfg with the values of keys matching pattern
+// replaced, in nested objects too
+func redact(cfg map[string]interface{}, pattern *regexp.Regexp) map[string]interface{} {
+	out := make(map[string]interface{}, len(cfg))
+	for k, v := range cfg {
+		if pattern.MatchString(k) {
+			out[k] = redacted
+			continue
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = redact(nested, pattern)
+		}
+		out[k] = v
+	}
+	return out
+}
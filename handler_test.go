@@ -0,0 +1,67 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestHandler(t *testing.T) {
+	ts := newFakeApollo(`{"port":"8080","db.password":"hunter2"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), IgnoreLocalSettings(), WithLogger(discard))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	defer stopWatch(a)
+
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var state struct {
+		Config      map[string]interface{} `json:"config"`
+		ReleaseKeys map[string]string      `json:"releaseKeys"`
+		Watching    bool                   `json:"watching"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if state.Config["port"] != "8080" || state.Config["db.password"] != redacted {
+		t.Fatalf("unexpected config %v", state.Config)
+	}
+	if state.ReleaseKeys["application"] != "r1" || !state.Watching {
+		t.Fatalf("unexpected metadata %+v", state)
+	}
+
+	rec = httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cfg := map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"user": "root", "apiKey": "k"},
+	}
+	out := redact(cfg, regexp.MustCompile(`(?i)key`))
+	if out["name"] != "app" || out["db"].(map[string]interface{})["apiKey"] != redacted {
+		t.Fatalf("unexpected redaction %v", out)
+	}
+	if cfg["db"].(map[string]interface{})["apiKey"] != "k" {
+		t.Fatalf("expected redact to leave the configuration untouched")
+	}
+}
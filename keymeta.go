@@ -0,0 +1,109 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// itemTimeLayout is the layout of the item times served by the portal open api
+const itemTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// KeyMeta is the metadata of a key, as edited in the portal
+type KeyMeta struct {
+	Namespace  string
+	Comment    string
+	ModifiedBy string
+	ModifiedAt time.Time
+}
+
+// openItem is an item of a namespace as served by the portal open api
+type openItem struct {
+	Key                        string `json:"key"`
+	Comment                    string `json:"comment"`
+	DataChangeLastModifiedBy   string `json:"dataChangeLastModifiedBy"`
+	DataChangeLastModifiedTime string `json:"dataChangeLastModifiedTime"`
+}
+
+// KeyMetadata reads the comment and last modification of keys from the open
+// api of the portal set by Portal, each time a namespace is released, for
+// KeyMeta. Authenticate requests to the open api with RequestMutator. Failing
+// to read metadata is logged and doesn't fail loads.
+func KeyMetadata() Option {
+	return optionFunc(func(a *Apollo) {
+		a.keyMetadata = true
+	})
+}
+
+// KeyMeta returns the metadata of key, as defined by the first namespace
+// defining it. It returns false if key is unknown or KeyMetadata isn't set,
+// keys are those of apollo, before any KeyTransformer.
+func (a *Apollo) KeyMeta(key string) (KeyMeta, bool) {
+	a.keyMetaMu.RLock()
+	defer a.keyMetaMu.RUnlock()
+	for _, namespace := range a.namespaces {
+		if meta, ok := a.keyMeta[namespace][key]; ok {
+			return meta, true
+		}
+	}
+	return KeyMeta{}, false
+}
+
+// refreshKeyMeta Read the metadata of the keys of namespace from the portal
+func (a *Apollo) refreshKeyMeta(namespace string) {
+	meta, err := a.readKeyMeta(namespace)
+	if err != nil {
+		a.logger.Printf("Failed reading key metadata of %s: %v", namespace, err)
+		return
+	}
+	a.keyMetaMu.Lock()
+	defer a.keyMetaMu.Unlock()
+	if a.keyMeta == nil {
+		a.keyMeta = map[string]map[string]KeyMeta{}
+	}
+	a.keyMeta[namespace] = meta
+}
+
+// readKeyMeta Read the items of namespace from the portal open api
+func (a *Apollo) readKeyMeta(namespace string) (map[string]KeyMeta, error) {
+	s := a.sourceOf(namespace)
+	uri := fmt.Sprintf("%s/openapi/v1/envs/%s/apps/%s/clusters/%s/namespaces/%s",
+		a.portal, url.PathEscape(a.portalEnv), url.PathEscape(s.AppID),
+		url.PathEscape(s.Cluster), url.PathEscape(s.Namespace))
+	resp, err := a.send(a.baseCtx, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	b, err := a.readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var ns struct {
+		Items []openItem `json:"items"`
+	}
+	if err := a.json().Unmarshal(b, &ns); err != nil {
+		return nil, newInvalidResponse(resp, b, err)
+	}
+
+	meta := make(map[string]KeyMeta, len(ns.Items))
+	for _, item := range ns.Items {
+		m := KeyMeta{
+			Namespace:  namespace,
+			Comment:    item.Comment,
+			ModifiedBy: item.DataChangeLastModifiedBy,
+		}
+		m.ModifiedAt, _ = time.Parse(itemTimeLayout, item.DataChangeLastModifiedTime)
+		meta[item.Key] = m
+	}
+	return meta, nil
+}
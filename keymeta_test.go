@@ -0,0 +1,48 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestKeyMeta(t *testing.T) {
+	var items int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"port":"8080"},"releaseKey":"r1"}`))
+	})
+	mux.HandleFunc("/openapi/v1/envs/DEV/apps/app/clusters/default/namespaces/application", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&items, 1)
+		_, _ = w.Write([]byte(`{"items":[{"key":"port","value":"8080","comment":"http port",` +
+			`"dataChangeLastModifiedBy":"alice","dataChangeLastModifiedTime":"2022-05-02T10:00:00.000+0800"}]}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Portal(ts.URL, "DEV"), KeyMetadata(), WithLogger(discard))
+	if _, ok := a.KeyMeta("port"); ok {
+		t.Fatal("expected no metadata before loading")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := a.load(); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+	}
+	meta, ok := a.KeyMeta("port")
+	if !ok || meta.Comment != "http port" || meta.ModifiedBy != "alice" || meta.ModifiedAt.IsZero() || meta.Namespace != "application" {
+		t.Fatalf("unexpected metadata %+v, %v", meta, ok)
+	}
+	if n := atomic.LoadInt32(&items); n != 1 {
+		t.Fatalf("expected metadata read once per release, read %d times", n)
+	}
+	if _, ok := a.KeyMeta("missing"); ok {
+		t.Fatal("expected no metadata for a missing key")
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import "errors"
+
+// Lazy defers reading configurations from apollo, watching them and mapping
+// them to the struct interface from InitViperRemote to the first access by a
+// read accessor of Apollo, e.g. GetDuration, Keys or Viper, or to an explicit
+// Load. Command line tools whose commands mostly don't need configurations
+// then start without waiting for apollo.
+//
+// Reads through viper can't trigger the load, so InitViperRemote returns no
+// viper and Remote stays nil until configurations are loaded, rather than
+// silently reading an empty configuration. Read through the accessors of
+// Apollo, or call Load before using Remote.
+func Lazy() Option {
+	return optionFunc(func(a *Apollo) {
+		a.lazy = true
+	})
+}
+
+// Load reads configurations from apollo if Lazy deferred it, once however
+// many times it is called, and returns the error of that first load. It
+// returns nil at once if configurations are not loaded lazily.
+func (a *Apollo) Load() error {
+	if !a.lazy {
+		return nil
+	}
+	a.lazyOnce.Do(func() {
+		if a.lazyRemote == nil {
+			a.lazyErr = errors.New("failed loading apollo config: viper remote not initialized")
+		} else {
+			Remote = a.lazyRemote
+			a.lazyErr = a.start()
+		}
+		if a.lazyErr != nil {
+			a.logger.Printf("%v", a.lazyErr)
+		}
+	})
+	return a.lazyErr
+}
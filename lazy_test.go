@@ -0,0 +1,55 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLazy(t *testing.T) {
+	var reads int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reads, 1)
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"timeout":"30s"},"releaseKey":"r1"}`))
+	})
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	Remote = nil
+	a := InitApollo(Server(ts.URL), AppId("app"), Lazy(), WithLogger(discard))
+	v, err := InitViperRemote(a, viper.KeyDelimiter(":"))
+	if err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	defer stopWatch(a)
+	if got := atomic.LoadInt32(&reads); got != 0 {
+		t.Fatalf("expected no read before first access, got %d", got)
+	}
+	if v != nil || Remote != nil {
+		t.Fatal("expected no viper handed out before configurations are loaded")
+	}
+	for i := 0; i < 2; i++ {
+		d, err := a.GetDuration("timeout")
+		if err != nil || d.Seconds() != 30 {
+			t.Fatalf("GetDuration: %v, %v", d, err)
+		}
+	}
+	if got := atomic.LoadInt32(&reads); got != 1 {
+		t.Fatalf("expected a single read, got %d", got)
+	}
+	if got := Remote.GetString("timeout"); got != "30s" {
+		t.Fatalf("expected the remote viper populated after first access, got %q", got)
+	}
+}
@@ -0,0 +1,511 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Namespaces sets the namespaces loaded from apollo, replacing NamespaceName.
+// Their configurations are merged, earlier namespaces taking precedence over
+// later ones for keys defined in several namespaces, as apollo clients do.
+func Namespaces(namespaces ...string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.namespaces = namespaces
+	})
+}
+
+// defaultMaxNamespaces is the default limit of namespaces an instance loads
+const defaultMaxNamespaces = 50
+
+// MaxNamespaces limits the namespaces an instance loads and watches to n, 50
+// by default, a guard against misconfiguration loading hundreds of them. A
+// limit of 0 or less disables the guard.
+func MaxNamespaces(n int) Option {
+	return optionFunc(func(a *Apollo) {
+		a.maxNamespaces = n
+	})
+}
+
+// checkNamespaces Return ErrTooManyNamespaces if more namespaces than
+// MaxNamespaces allows are loaded
+func (a *Apollo) checkNamespaces() error {
+	if a.maxNamespaces > 0 && len(a.namespaces) > a.maxNamespaces {
+		return fmt.Errorf("%w: %d namespaces, at most %d allowed by MaxNamespaces", ErrTooManyNamespaces, len(a.namespaces), a.maxNamespaces)
+	}
+	return nil
+}
+
+// ConcurrentLoad fetches up to workers namespaces at once instead of one after
+// the other. Key filters and secret resolvers may then be called concurrently.
+func ConcurrentLoad(workers int) Option {
+	return optionFunc(func(a *Apollo) {
+		a.loadWorkers = workers
+	})
+}
+
+// BestEffortLoad keeps the namespaces that could be loaded when others fail,
+// instead of failing the whole load. Loading fails only if no namespace could
+// be loaded.
+func BestEffortLoad() Option {
+	return optionFunc(func(a *Apollo) {
+		a.bestEffort = true
+	})
+}
+
+// Viper returns a viper holding only the configurations of namespace, kept in
+// sync as modifications of namespace are loaded from apollo. It returns nil if
+// namespace is not loaded by this instance.
+func (a *Apollo) Viper(namespace string) *viper.Viper {
+	if !a.hasNamespace(namespace) {
+		return nil
+	}
+	_ = a.Load()
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	if v, ok := a.namespaceVipers[namespace]; ok {
+		return v
+	}
+
+	v := viper.NewWithOptions(a.viperOptions...)
+	v.SetConfigType("json")
+	if cfg, ok := a.namespaceConfigs[namespace]; ok {
+		a.readInto(v, cfg)
+	}
+	if a.namespaceVipers == nil {
+		a.namespaceVipers = map[string]*viper.Viper{}
+	}
+	a.namespaceVipers[namespace] = v
+	return v
+}
+
+// NamespaceChecksum returns the sha256 of the configuration of namespace as
+// last served by apollo, before any transformation. The configuration is
+// canonicalized first, so the checksum doesn't depend on key order and can be
+// compared across instances to detect drift.
+func (a *Apollo) NamespaceChecksum(namespace string) (string, error) {
+	if err := a.Load(); err != nil {
+		return "", err
+	}
+	a.configMu.RLock()
+	content, ok := a.namespaceContent[namespace]
+	a.configMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("namespace %s not loaded", namespace)
+	}
+
+	// encoding/json sorts map keys, whatever codec is in use
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(content))
+	d.UseNumber()
+	if len(bytes.TrimSpace(content)) > 0 {
+		if err := d.Decode(&v); err != nil {
+			return "", err
+		}
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RawConfigurations returns the configurations of the first namespace exactly
+// as served by apollo, without the parsing and merging which reorder keys and
+// coerce values, to be passed through as is. They are those of the last load,
+// or fetched from apollo if none happened yet. Namespaces read from the disk
+// cache are returned as cached.
+func (a *Apollo) RawConfigurations() (json.RawMessage, error) {
+	a.configMu.RLock()
+	content, ok := a.namespaceContent[a.namespaceName]
+	a.configMu.RUnlock()
+	if ok {
+		return append(json.RawMessage(nil), content...), nil
+	}
+	resp, err := a.get(a.baseCtx, a.namespacePath("configs", a.namespaceName))
+	if err != nil {
+		return nil, err
+	}
+	return append(json.RawMessage(nil), resp.Configurations...), nil
+}
+
+// CompareNamespaces fetches ns1 and ns2 from apollo and compares their keys:
+// onlyIn1 and onlyIn2 are the keys defined in one namespace only, differing
+// the keys defined in both with different values, all sorted. The namespaces
+// need not be loaded by this instance, e.g. to check that a new namespace
+// covers all the keys of an old one before switching to it.
+func (a *Apollo) CompareNamespaces(ns1, ns2 string) (onlyIn1, onlyIn2, differing []string, err error) {
+	cfg1, err := a.fetchNamespace(ns1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg2, err := a.fetchNamespace(ns2)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, k := range sortedKeys(cfg1) {
+		v2, ok := cfg2[k]
+		if !ok {
+			onlyIn1 = append(onlyIn1, k)
+		} else if !reflect.DeepEqual(cfg1[k], v2) {
+			differing = append(differing, k)
+		}
+	}
+	for _, k := range sortedKeys(cfg2) {
+		if _, ok := cfg1[k]; !ok {
+			onlyIn2 = append(onlyIn2, k)
+		}
+	}
+	return onlyIn1, onlyIn2, differing, nil
+}
+
+// fetchNamespace Read the configurations of namespace from apollo, without
+// loading them
+func (a *Apollo) fetchNamespace(namespace string) (map[string]interface{}, error) {
+	resp, err := a.get(a.baseCtx, a.namespacePath("configs", namespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching namespace %s: %w", namespace, err)
+	}
+	cfg, err := a.configurations(namespace, resp.Configurations)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.processMerged(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// hasNamespace Report whether namespace is loaded by this instance
+func (a *Apollo) hasNamespace(namespace string) bool {
+	for _, n := range a.namespaces {
+		if n == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// setNamespaceConfig Keep configurations loaded for namespace, updating its
+// viper if they changed
+func (a *Apollo) setNamespaceConfig(namespace string, cfg map[string]interface{}, content []byte) {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	if a.namespaceConfigs == nil {
+		a.namespaceConfigs = map[string]map[string]interface{}{}
+		a.namespaceContent = map[string][]byte{}
+	}
+	a.namespaceContent[namespace] = content
+	changed := !reflect.DeepEqual(a.namespaceConfigs[namespace], cfg)
+	a.namespaceConfigs[namespace] = cfg
+	if v, ok := a.namespaceVipers[namespace]; ok && changed {
+		a.readInto(v, cfg)
+	}
+}
+
+// readInto Replace the configurations held by v with cfg
+func (a *Apollo) readInto(v *viper.Viper, cfg map[string]interface{}) {
+	b, err := a.json().Marshal(cfg)
+	if err == nil {
+		err = v.ReadConfig(bytes.NewReader(b))
+	}
+	if err != nil {
+		a.logger.Printf("Failed updating namespace viper: %v", err)
+	}
+}
+
+// loadNamespaces Load configurations of every namespace, returned in the order
+// of namespaces whatever the order they were fetched in, nil for namespaces
+// which failed with BestEffortLoad. The source is SourceCache if any namespace
+// was read from the disk cache.
+func (a *Apollo) loadNamespaces() ([]*namespaceLoad, ConfigSource, error) {
+	loads := make([]*namespaceLoad, len(a.namespaces))
+	errs := make([]error, len(a.namespaces))
+	workers := a.loadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+	sem := make(chan struct{}, workers)
+	for i, namespace := range a.namespaces {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := failed && !a.bestEffort
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, namespace string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			loads[i], errs[i] = a.loadNamespace(a.baseCtx, namespace)
+			if errs[i] != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(i, namespace)
+	}
+	wg.Wait()
+
+	var firstErr error
+	loaded := 0
+	source := SourceRemote
+	for i, err := range errs {
+		if err == nil {
+			loaded++
+			if loads[i].source == SourceCache {
+				source = SourceCache
+			}
+			continue
+		}
+		if !a.bestEffort {
+			return nil, "", err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		a.logger.Printf("Failed loading apollo namespace %s: %v", a.namespaces[i], err)
+		a.startupError(fmt.Errorf("namespace %s: %w", a.namespaces[i], err))
+	}
+	if loaded == 0 {
+		return nil, "", fmt.Errorf("no apollo namespace could be loaded: %w", firstErr)
+	}
+	return loads, source, nil
+}
+
+// PartialLoad is the result of LoadWithin
+type PartialLoad struct {
+	// Config holds the merged configurations of the namespaces loaded in
+	// time
+	Config map[string]interface{}
+	// TimedOut lists the namespaces not loaded when the deadline expired
+	TimedOut []string
+	// Failed holds the errors of namespaces which failed in time
+	Failed map[string]error
+}
+
+// LoadWithin loads the configurations of every namespace from apollo until ctx
+// is done, and returns those loaded in time merged along with the namespaces
+// which timed out or failed, instead of failing as a whole. It is meant for
+// read-only tooling preferring partial data to none: configurations are not
+// applied, cached or watched, and the disk cache is not used. An error is
+// returned only if no namespace could be loaded.
+func (a *Apollo) LoadWithin(ctx context.Context) (PartialLoad, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		i   int
+		cfg map[string]interface{}
+		err error
+	}
+	workers := a.loadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	results := make(chan result, len(a.namespaces))
+	for i, namespace := range a.namespaces {
+		go func(i int, namespace string) {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			r := result{i: i}
+			resp, err := a.get(ctx, a.namespacePath("configs", namespace))
+			if err == nil {
+				r.cfg, err = a.configurations(namespace, resp.Configurations)
+			}
+			r.err = err
+			results <- r
+		}(i, namespace)
+	}
+
+	configs := make([]map[string]interface{}, len(a.namespaces))
+	done := make([]bool, len(a.namespaces))
+	partial := PartialLoad{Failed: map[string]error{}}
+	var firstErr error
+wait:
+	for range a.namespaces {
+		select {
+		case r := <-results:
+			done[r.i] = true
+			if r.err != nil {
+				partial.Failed[a.namespaces[r.i]] = r.err
+				if firstErr == nil {
+					firstErr = r.err
+				}
+				continue
+			}
+			configs[r.i] = r.cfg
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	loaded := 0
+	for i, namespace := range a.namespaces {
+		if !done[i] {
+			partial.TimedOut = append(partial.TimedOut, namespace)
+		} else if configs[i] != nil {
+			loaded++
+		}
+	}
+	if loaded == 0 {
+		if firstErr == nil {
+			firstErr = ctx.Err()
+		}
+		return partial, fmt.Errorf("no apollo namespace could be loaded: %w", firstErr)
+	}
+	merged, err := a.mergeConfigs(configs)
+	if err != nil {
+		return partial, err
+	}
+	partial.Config = merged
+	return partial, nil
+}
+
+// MergeStrategy controls how configurations of namespaces defining the same
+// key are combined
+type MergeStrategy int
+
+const (
+	// ShallowReplace keeps the value of the earlier namespace, replacing
+	// nested objects as a whole
+	ShallowReplace MergeStrategy = iota
+	// DeepMerge merges nested objects recursively, the earlier namespace
+	// winning for scalars and arrays defined in both
+	DeepMerge
+)
+
+// Merge sets how configurations of namespaces defining the same key are
+// combined, ShallowReplace by default. Nested objects come from namespaces in
+// a structured format, e.g. db.yaml.
+func Merge(strategy MergeStrategy) Option {
+	return optionFunc(func(a *Apollo) {
+		a.mergeStrategy = strategy
+	})
+}
+
+// PrefixByNamespace nests the configurations of each namespace under the
+// namespace name, so host of the database namespace is read as database:host
+// with the ':' key delimiter. Namespaces can then define the same keys without
+// colliding, and Merge has no effect.
+func PrefixByNamespace() Option {
+	return optionFunc(func(a *Apollo) {
+		a.prefixByNamespace = true
+	})
+}
+
+// mergeConfigs Merge configurations of namespaces, given in the order of
+// namespaces, earlier ones taking precedence, and apply the transformations
+// of the whole configuration to the result
+func (a *Apollo) mergeConfigs(configs []map[string]interface{}) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	if a.prefixByNamespace {
+		for i, cfg := range configs {
+			if cfg == nil {
+				continue
+			}
+			if err := a.processMerged(cfg); err != nil {
+				return nil, err
+			}
+			merged[a.namespaces[i]] = cfg
+		}
+		return merged, nil
+	}
+	for i := len(configs) - 1; i >= 0; i-- {
+		if a.mergeStrategy == DeepMerge {
+			deepMerge(merged, configs[i])
+			continue
+		}
+		for k, v := range configs[i] {
+			merged[k] = v
+		}
+	}
+	if err := a.processMerged(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// deepMerge Merge src into dst, merging nested objects and replacing other
+// values. Nested objects of src are copied, never modified.
+func deepMerge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dstMap, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dstMap = map[string]interface{}{}
+			dst[k] = dstMap
+		}
+		deepMerge(dstMap, srcMap)
+	}
+}
+
+// updateNotifications Keep the notification ids of changed namespaces
+func (a *Apollo) updateNotifications(changed []notification) {
+	for _, c := range changed {
+		for i := range a.notifications {
+			if a.notifications[i].NamespaceName == c.NamespaceName {
+				a.notifications[i].NotificationID = c.NotificationID
+			}
+		}
+	}
+	if len(changed) > 0 {
+		a.recordChangedNamespaces(changed)
+		if err := a.saveNotifications(); err != nil {
+			a.logger.Printf("Failed writing notification ids: %v", err)
+		}
+	}
+}
+
+// recordChangedNamespaces Keep the namespaces of changed notifications until
+// they are delivered to the OnBatchChange callback
+func (a *Apollo) recordChangedNamespaces(changed []notification) {
+	if a.onBatchChange == nil {
+		return
+	}
+	a.changedMu.Lock()
+	defer a.changedMu.Unlock()
+	for _, c := range changed {
+		if a.hasNamespace(c.NamespaceName) && !stringInSlice(c.NamespaceName, a.changedNamespaces) {
+			a.changedNamespaces = append(a.changedNamespaces, c.NamespaceName)
+		}
+	}
+}
+
+// takeChangedNamespaces Return the namespaces changed since the last call
+func (a *Apollo) takeChangedNamespaces() []string {
+	a.changedMu.Lock()
+	defer a.changedMu.Unlock()
+	changed := a.changedNamespaces
+	a.changedNamespaces = nil
+	return changed
+}
@@ -0,0 +1,435 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// namespaceServer serves the configurations of each namespace, which tests
+// may change between loads
+type namespaceServer struct {
+	mu      sync.Mutex
+	configs map[string]string
+}
+
+func (s *namespaceServer) set(namespace, configurations string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configs[namespace] = configurations
+}
+
+func (s *namespaceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	namespace := path.Base(r.URL.Path)
+	_, _ = w.Write([]byte(`{"appId":"app","namespaceName":"` + namespace +
+		`","configurations":` + s.configs[namespace] + `,"releaseKey":"` + namespace + `-r1"}`))
+}
+
+func TestNamespacesMerge(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"a":"app","b":"app"}`,
+		"shared":      `{"b":"shared","c":"shared"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "shared"), WithLogger(discard))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := map[string]string{"a": "app", "b": "app", "c": "shared"}
+	for k, v := range want {
+		if got := a.config[k]; got != v {
+			t.Fatalf("expected %s=%s, got %v", k, v, got)
+		}
+	}
+	if got := a.releaseKey("shared"); got != "shared-r1" {
+		t.Fatalf("expected release key of shared, got %q", got)
+	}
+}
+
+func TestInterpolateAcrossNamespaces(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"db_url":"jdbc://${db_host}/app","port":"${db_port}","api":"{{.db_host}}"}`,
+		"common":      `{"db_host":"db","db_port":"5432"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "common"),
+		EnableInterpolation(), StrictInterpolation(), TemplateValues(), InferTypes(), WithLogger(discard))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	cfg := a.configCopy()
+	if cfg["db_url"] != "jdbc://db/app" || cfg["port"] != 5432 || cfg["api"] != "db" {
+		t.Fatalf("unexpected configurations %#v", cfg)
+	}
+
+	a = InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "common"),
+		PrefixByNamespace(), EnableInterpolation(), StrictInterpolation(), WithLogger(discard))
+	if _, err := a.load(); err == nil || !strings.Contains(err.Error(), "unresolved reference") {
+		t.Fatalf("expected references resolved within their namespace, got %v", err)
+	}
+}
+
+func TestNamespaceViper(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"a":"1"}`,
+		"shared":      `{"b":"1"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "shared"), WithLogger(discard))
+	if v := a.Viper("missing"); v != nil {
+		t.Fatalf("expected nil viper for unknown namespace")
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	app, shared := a.Viper("application"), a.Viper("shared")
+	if got := app.GetString("a"); got != "1" {
+		t.Fatalf("expected a=1, got %q", got)
+	}
+	if app.IsSet("b") {
+		t.Fatalf("expected b of shared not to be in application viper")
+	}
+
+	s.set("shared", `{"b":"2"}`)
+	app.Set("local", "kept")
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := shared.GetString("b"); got != "2" {
+		t.Fatalf("expected b=2 after change, got %q", got)
+	}
+	if got := app.GetString("local"); got != "kept" {
+		t.Fatalf("expected unchanged namespace viper not to be reloaded")
+	}
+}
+
+func TestUpdateNotifications(t *testing.T) {
+	a := InitApollo(Server("http://localhost"), AppId("app"), Namespaces("application", "shared"))
+	a.updateNotifications([]notification{{NamespaceName: "shared", NotificationID: 7}})
+	if got := a.notifications[0].NotificationID; got != -1 {
+		t.Fatalf("expected application notification to be kept, got %d", got)
+	}
+	if got := a.notifications[1].NotificationID; got != 7 {
+		t.Fatalf("expected shared notification 7, got %d", got)
+	}
+}
+
+func TestConcurrentLoad(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"a": `{"k":"a"}`,
+		"b": `{"k":"b"}`,
+		"c": `{"k":"c","c":"1"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("a", "b", "c"), ConcurrentLoad(3))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.config["k"]; got != "a" {
+		t.Fatalf("expected first namespace to take precedence, got %v", got)
+	}
+	if got := a.config["c"]; got != "1" {
+		t.Fatalf("expected c=1 from last namespace, got %v", got)
+	}
+}
+
+func TestBestEffortLoad(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"good": `{"k":"1"}`,
+		"bad":  `not json`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("good", "bad"), ConcurrentLoad(2), WithLogger(discard))
+	if _, err := a.load(); err == nil {
+		t.Fatal("expected failing namespace to fail the load")
+	}
+
+	a = InitApollo(Server(ts.URL), AppId("app"), Namespaces("good", "bad"), ConcurrentLoad(2),
+		BestEffortLoad(), WithLogger(discard))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("expected best effort load to succeed, got %v", err)
+	}
+	if got := a.config["k"]; got != "1" {
+		t.Fatalf("expected k=1 from good namespace, got %v", got)
+	}
+
+	a = InitApollo(Server(ts.URL), AppId("app"), Namespaces("bad"), BestEffortLoad(), WithLogger(discard))
+	if _, err := a.load(); err == nil {
+		t.Fatal("expected an error when no namespace could be loaded")
+	}
+}
+
+func TestFileStyleNamespaces(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"app.name":"demo"}`,
+		"db.yaml":     `{"content":"db:\n  host: localhost\n  port: 5432\n"}`,
+		"notes.txt":   `{"content":"free text"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "db.yaml", "notes.txt"))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.config["app.name"]; got != "demo" {
+		t.Fatalf("expected flat key app.name, got %v", got)
+	}
+	db, ok := a.config["db"].(map[string]interface{})
+	if !ok || db["host"] != "localhost" {
+		t.Fatalf("expected parsed yaml db section, got %#v", a.config["db"])
+	}
+	if got := a.config["notes.txt"]; got != "free text" {
+		t.Fatalf("expected txt content under its namespace, got %v", got)
+	}
+	if _, ok := a.config["content"]; ok {
+		t.Fatal("expected content key not to be merged")
+	}
+}
+
+func TestNamespaceChecksum(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"a": `{"x":"1","y":"2"}`,
+		"b": `{"y":"2","x":"1"}`,
+		"c": `{"x":"1","y":"3"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("a", "b", "c"))
+	if _, err := a.NamespaceChecksum("a"); err == nil {
+		t.Fatal("expected an error before loading")
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	sums := map[string]string{}
+	for _, ns := range []string{"a", "b", "c"} {
+		sum, err := a.NamespaceChecksum(ns)
+		if err != nil {
+			t.Fatalf("checksum of %s: %v", ns, err)
+		}
+		sums[ns] = sum
+	}
+	if sums["a"] != sums["b"] {
+		t.Fatal("expected checksum not to depend on key order")
+	}
+	if sums["a"] == sums["c"] {
+		t.Fatal("expected different configurations to have different checksums")
+	}
+}
+
+func TestMergeStrategy(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"app.yaml":    `{"content":"logging:\n  level: debug\n"}`,
+		"shared.yaml": `{"content":"logging:\n  level: info\n  format: json\n"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		strategy MergeStrategy
+		format   interface{}
+	}{
+		{ShallowReplace, nil},
+		{DeepMerge, "json"},
+	} {
+		a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("app.yaml", "shared.yaml"), Merge(tc.strategy))
+		if _, err := a.load(); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		logging := a.config["logging"].(map[string]interface{})
+		if logging["level"] != "debug" || logging["format"] != tc.format {
+			t.Fatalf("strategy %d: unexpected logging %v", tc.strategy, logging)
+		}
+		shared := a.namespaceConfigs["shared.yaml"]["logging"].(map[string]interface{})
+		if shared["level"] != "info" {
+			t.Fatalf("strategy %d: expected namespace configuration not to be modified, got %v", tc.strategy, shared)
+		}
+	}
+}
+
+func TestPrefixByNamespace(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"host":"app.local"}`,
+		"database":    `{"host":"db.local"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "database"), PrefixByNamespace())
+	v := viper.NewWithOptions(viper.KeyDelimiter(":"))
+	v.SetConfigType("json")
+	b, err := a.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := v.GetString("database:host"); got != "db.local" {
+		t.Fatalf("expected database:host=db.local, got %q", got)
+	}
+	if got := v.GetString("application:host"); got != "app.local" {
+		t.Fatalf("expected application:host=app.local, got %q", got)
+	}
+	if v.IsSet("host") {
+		t.Fatalf("expected unprefixed host not to be set")
+	}
+}
+
+func TestNotificationsBodyDeduplicated(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), Namespaces("application", "shared", "application"))
+	a.updateNotifications([]notification{{NamespaceName: "application", NotificationID: 3}})
+	a.notifications = append(a.notifications, notification{NamespaceName: "shared", NotificationID: 2})
+
+	want := `[{"namespaceName":"application","notificationId":3},{"namespaceName":"shared","notificationId":2}]`
+	if got := a.getNotificationsBody(a.notifications); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestLoadWithin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "slow":
+			<-r.Context().Done()
+		case "broken":
+			_, _ = w.Write([]byte(`<html>`))
+		default:
+			_, _ = w.Write([]byte(`{"configurations":{"a":"app"}}`))
+		}
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "slow", "broken"), ConcurrentLoad(3))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	partial, err := a.LoadWithin(ctx)
+	if err != nil {
+		t.Fatalf("LoadWithin: %v", err)
+	}
+	if partial.Config["a"] != "app" {
+		t.Fatalf("unexpected config %v", partial.Config)
+	}
+	if len(partial.TimedOut) != 1 || partial.TimedOut[0] != "slow" {
+		t.Fatalf("expected slow to time out, got %v", partial.TimedOut)
+	}
+	if _, ok := partial.Failed["broken"]; !ok || len(partial.Failed) != 1 {
+		t.Fatalf("expected broken to fail, got %v", partial.Failed)
+	}
+	if a.Source() != SourceLocal {
+		t.Fatalf("expected partial configuration not to be applied")
+	}
+}
+
+func TestOnBatchChange(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"a":"1"}`,
+		"shared":      `{"b":"1"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var batches [][]string
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "shared"), WithLogger(discard),
+		OnBatchChange(func(changed []string) {
+			batches = append(batches, changed)
+		}))
+	useRemote(t, a)
+	a.updateNotifications([]notification{
+		{NamespaceName: "application", NotificationID: 2},
+		{NamespaceName: "shared", NotificationID: 3},
+	})
+	a.updateNotifications([]notification{{NamespaceName: "shared", NotificationID: 4}})
+	a.applyChange()
+	a.applyChange()
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0] != "application" || batches[0][1] != "shared" {
+		t.Fatalf("expected a single batch of application and shared, got %v", batches)
+	}
+}
+
+func TestMaxNamespaces(t *testing.T) {
+	namespaces := make([]string, defaultMaxNamespaces+1)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("ns%d", i)
+	}
+	func() {
+		defer func() {
+			r := recover()
+			if r == nil || !strings.Contains(fmt.Sprint(r), ErrTooManyNamespaces.Error()) {
+				t.Fatalf("expected init to fail with too many namespaces, got %v", r)
+			}
+		}()
+		InitApollo(Server("http://localhost:8080"), AppId("app"), Namespaces(namespaces...), WithLogger(discard))
+	}()
+
+	a := InitApollo(Server("http://localhost:8080"), AppId("app"), Namespaces(namespaces...), MaxNamespaces(0), WithLogger(discard))
+	if len(a.namespaces) != defaultMaxNamespaces+1 {
+		t.Fatalf("expected the guard disabled, got %d namespaces", len(a.namespaces))
+	}
+}
+
+func TestRawConfigurations(t *testing.T) {
+	ts := newFakeApollo(`{"b":"2", "a":1}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	raw, err := a.RawConfigurations()
+	if err != nil || string(raw) != `{"b":"2", "a":1}` {
+		t.Fatalf("expected the configurations fetched untouched, got %s, %v", raw, err)
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ts.Close()
+	raw, err = a.RawConfigurations()
+	if err != nil || string(raw) != `{"b":"2", "a":1}` {
+		t.Fatalf("expected the configurations of the last load, got %s, %v", raw, err)
+	}
+}
+
+func TestCompareNamespaces(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"old": `{"a":"1","b":"2","c":"3"}`,
+		"new": `{"b":"2","c":"4","d":"5"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	onlyOld, onlyNew, differing, err := a.CompareNamespaces("old", "new")
+	if err != nil {
+		t.Fatalf("CompareNamespaces: %v", err)
+	}
+	if !reflect.DeepEqual(onlyOld, []string{"a"}) || !reflect.DeepEqual(onlyNew, []string{"d"}) || !reflect.DeepEqual(differing, []string{"c"}) {
+		t.Fatalf("unexpected comparison %v, %v, %v", onlyOld, onlyNew, differing)
+	}
+}
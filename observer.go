@@ -0,0 +1,63 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of the activity of the client, e.g. to export metrics.
+// Any hook may be nil. Hooks are called synchronously from the goroutine doing
+// the work and should return quickly.
+type Observer struct {
+	// ReloadsInFlight is called with the number of namespace reloads running
+	// whenever a reload starts or ends
+	ReloadsInFlight func(n int)
+	// StaleConfig is called when no notification poll succeeded for the
+	// StaleAfter window, with the time elapsed since the last success
+	StaleConfig func(since time.Duration)
+}
+
+// WithObserver registers hooks notified of the activity of the client
+func WithObserver(o Observer) Option {
+	return optionFunc(func(a *Apollo) {
+		a.observer = o
+	})
+}
+
+// MaxConcurrentReloads caps to n the namespace reloads running at once across
+// all loads, excess reloads wait for a slot. It smooths resource usage when
+// many namespaces change at once, whatever ConcurrentLoad is set to. A cap of
+// 0 or less is ignored.
+func MaxConcurrentReloads(n int) Option {
+	return optionFunc(func(a *Apollo) {
+		if n > 0 {
+			a.reloadSlots = make(chan struct{}, n)
+		}
+	})
+}
+
+// beginReload Wait for a reload slot and report the reload as in flight, the
+// returned func ends it
+func (a *Apollo) beginReload() func() {
+	if a.reloadSlots != nil {
+		a.reloadSlots <- struct{}{}
+	}
+	a.observeReloads(atomic.AddInt32(&a.reloadsInFlight, 1))
+	return func() {
+		a.observeReloads(atomic.AddInt32(&a.reloadsInFlight, -1))
+		if a.reloadSlots != nil {
+			<-a.reloadSlots
+		}
+	}
+}
+
+func (a *Apollo) observeReloads(n int32) {
+	if a.observer.ReloadsInFlight != nil {
+		a.observer.ReloadsInFlight(int(n))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentReloads(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{},"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	var (
+		mu       sync.Mutex
+		max, end int
+	)
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("a", "b", "c", "d"),
+		ConcurrentLoad(4), MaxConcurrentReloads(2),
+		WithObserver(Observer{ReloadsInFlight: func(n int) {
+			mu.Lock()
+			defer mu.Unlock()
+			if n > max {
+				max = n
+			}
+			end = n
+		}}))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if max != 2 {
+		t.Fatalf("expected at most 2 reloads in flight, observed %d", max)
+	}
+	if end != 0 {
+		t.Fatalf("expected no reload in flight once loaded, observed %d", end)
+	}
+}
+
+func TestMaxConcurrentReloadsIgnoresInvalidCap(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{},"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	for _, n := range []int{0, -1} {
+		a := InitApollo(Server(ts.URL), AppId("app"), MaxConcurrentReloads(n))
+		if a.reloadSlots != nil {
+			t.Fatalf("expected cap %d to be ignored", n)
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := a.load()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("load with cap %d: %v", n, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("load with cap %d blocked", n)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+)
+
+// FetchOnce loads the configurations of the namespaces set by opts from apollo
+// once and returns them merged, without setting up viper or watching
+// modifications, for scripts and command line tools which exit afterwards.
+// Idle connections are closed before returning, unless they belong to a client
+// provided by HTTPClient. Invalid options are returned as errors.
+func FetchOnce(opts ...Option) (map[string]interface{}, error) {
+	client := &http.Client{Transport: longPollTransport()}
+	a, err := buildApollo(append([]Option{HTTPClient(client)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer a.closeIdleConnections(client)
+
+	if _, err := a.load(); err != nil {
+		return nil, err
+	}
+	return a.configCopy(), nil
+}
+
+// closeIdleConnections Close the idle connections of the transports owned by
+// the instance: the one of client, the internal client, and the one cloned
+// for transport options
+func (a *Apollo) closeIdleConnections(client *http.Client) {
+	client.CloseIdleConnections()
+	if a.clonedTransport != nil {
+		a.clonedTransport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchOnce(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"application": `{"a":"app"}`,
+		"shared":      `{"a":"shared","b":"shared"}`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	cfg, err := FetchOnce(Server(ts.URL), AppId("app"), Namespaces("application", "shared"))
+	if err != nil {
+		t.Fatalf("FetchOnce: %v", err)
+	}
+	if cfg["a"] != "app" || cfg["b"] != "shared" {
+		t.Fatalf("unexpected configurations %v", cfg)
+	}
+}
+
+func TestFetchOnceError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html>`))
+	}))
+	defer ts.Close()
+
+	if _, err := FetchOnce(Server(ts.URL), AppId("app")); err == nil {
+		t.Fatalf("expected an error for an invalid response")
+	}
+}
+
+func TestFetchOnceInvalidOptions(t *testing.T) {
+	if _, err := FetchOnce(AppId("app")); !errors.Is(err, ErrMissingArguments) {
+		t.Fatalf("expected ErrMissingArguments, got %v", err)
+	}
+}
+
+func TestFetchOnceClosesIdleConnections(t *testing.T) {
+	var closed int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	// IdleConnTimeout makes the instance clone the transport of its client
+	if _, err := FetchOnce(Server(ts.URL), AppId("app"), IdleConnTimeout(time.Minute)); err != nil {
+		t.Fatalf("FetchOnce: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&closed) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the connection used to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// MinPollInterval makes the watcher wait at least interval between two reads
+// of configuration, however fast notifications arrive, to protect apollo and
+// the application from notification storms. Modifications notified meanwhile
+// are read at once when the interval is over.
+func MinPollInterval(interval time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.minPollInterval = interval
+	})
+}
+
+// MaxPollInterval makes the watcher read configuration again if it wasn't
+// read for interval, as a safety net against missed notifications. The check
+// runs after each notification poll, which apollo holds up to a minute, and
+// OnChange only fires if the configuration read actually changed.
+func MaxPollInterval(interval time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.maxPollInterval = interval
+	})
+}
+
+// WatchStartDelay delays the first notification poll of the watcher by d,
+// so that the application finishes wiring up its change handlers before the
+// first modification is delivered. Modifications made meanwhile are reported
+// by the first poll.
+func WatchStartDelay(d time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.watchStartDelay = d
+	})
+}
+
+// NotModifiedStatus sets the status codes of the notification endpoint meaning
+// no namespace was modified, http.StatusNotModified by default, for backends
+// diverging from apollo conventions
+func NotModifiedStatus(codes ...int) Option {
+	return optionFunc(func(a *Apollo) {
+		a.notModifiedStatus = codes
+	})
+}
+
+// NotModifiedOnEmptyBody makes an empty notification response mean no
+// namespace was modified, whatever its status code, for backends answering
+// 200 with an empty body instead of 304
+func NotModifiedOnEmptyBody() Option {
+	return optionFunc(func(a *Apollo) {
+		a.notModifiedOnEmptyBody = true
+	})
+}
+
+// ConditionalNotifications makes notification polls conditional, sending a
+// weak validator derived from the watched notification ids in If-None-Match.
+// A backend supporting it answers 304, or echoes the validator in its ETag,
+// when no namespace was modified, so that no body is sent nor decoded on
+// no-op wakeups. Apollo itself ignores the header.
+func ConditionalNotifications() Option {
+	return optionFunc(func(a *Apollo) {
+		a.conditionalNotifications = true
+	})
+}
+
+// notificationsValidator Return the weak validator of a notifications query
+// parameter, the same for the same notification ids
+func notificationsValidator(notifications string) string {
+	sum := sha256.Sum256([]byte(notifications))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified Report whether a notification response with status and body
+// means no namespace was modified. body is nil if it wasn't read yet.
+func (a *Apollo) notModified(status int, body []byte) bool {
+	if body != nil && a.notModifiedOnEmptyBody && len(bytes.TrimSpace(body)) == 0 {
+		return true
+	}
+	if a.notModifiedStatus == nil {
+		return status == http.StatusNotModified
+	}
+	for _, code := range a.notModifiedStatus {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshDue Report whether configuration wasn't read for the max poll
+// interval
+func (a *Apollo) refreshDue() bool {
+	if a.maxPollInterval <= 0 {
+		return false
+	}
+	a.applyMu.Lock()
+	defer a.applyMu.Unlock()
+	return !a.lastApply.IsZero() && time.Since(a.lastApply) >= a.maxPollInterval
+}
+
+// waitStartDelay Wait for the delay set by WatchStartDelay, report false if
+// the watcher was stopped meanwhile
+func (a *Apollo) waitStartDelay(quit chan bool, done <-chan struct{}) bool {
+	if a.watchStartDelay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(a.watchStartDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// waitMinPollInterval Wait until the min poll interval has elapsed since
+// configuration was last read, report false if the watcher was stopped
+// meanwhile
+func (a *Apollo) waitMinPollInterval(quit chan bool, done <-chan struct{}) bool {
+	if a.minPollInterval <= 0 {
+		return true
+	}
+	a.applyMu.Lock()
+	last := a.lastApply
+	a.applyMu.Unlock()
+	if last.IsZero() {
+		return true
+	}
+	wait := a.minPollInterval - time.Since(last)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMinPollInterval(t *testing.T) {
+	var reads int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reads, 1)
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"},"releaseKey":"r1"}`))
+	})
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"namespaceName":"application","notificationId":1}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), MinPollInterval(100*time.Millisecond), WithLogger(discard))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	time.Sleep(350 * time.Millisecond)
+	stopWatch(a)
+	if got := atomic.LoadInt32(&reads); got < 2 || got > 5 {
+		t.Fatalf("expected reads throttled to about one per 100ms, got %d", got)
+	}
+}
+
+func TestMaxPollInterval(t *testing.T) {
+	var polls, changes int32
+	value := atomic.Value{}
+	value.Store("1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"` + value.Load().(string) + `"},"releaseKey":"r1"}`))
+	})
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&polls, 1) == 1 {
+			_, _ = w.Write([]byte(`[{"namespaceName":"application","notificationId":1}]`))
+			return
+		}
+		// Notifications are missed from now on
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusNotModified)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), MaxPollInterval(50*time.Millisecond), WithLogger(discard),
+		OnChange(func() { atomic.AddInt32(&changes, 1) }))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	defer stopWatch(a)
+
+	time.Sleep(200 * time.Millisecond)
+	if got := atomic.LoadInt32(&changes); got != 1 {
+		t.Fatalf("expected refreshes of unchanged config not to fire OnChange, got %d changes", got)
+	}
+	value.Store("2")
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&changes) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected missed modification to be refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := a.configCopy()["a"]; got != "2" {
+		t.Fatalf("expected refreshed a=2, got %v", got)
+	}
+}
+
+func TestNotModifiedDetection(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		status      int
+		body        string
+		opts        []Option
+		notModified bool
+	}{
+		{"304", http.StatusNotModified, "", nil, true},
+		{"200 with empty body by default", http.StatusOK, "", nil, false},
+		{"200 with empty body", http.StatusOK, " \n", []Option{NotModifiedOnEmptyBody()}, true},
+		{"200 with notifications", http.StatusOK, `[{"namespaceName":"application","notificationId":1}]`, []Option{NotModifiedOnEmptyBody()}, false},
+		{"custom status", http.StatusNoContent, "", []Option{NotModifiedStatus(http.StatusNoContent)}, true},
+		{"304 with custom status", http.StatusNotModified, "", []Option{NotModifiedStatus(http.StatusNoContent)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer ts.Close()
+
+			a := InitApollo(append([]Option{Server(ts.URL), AppId("app")}, tc.opts...)...)
+			modified, err := a.getNotifications()
+			if notModified := !modified && err == nil; notModified != tc.notModified {
+				t.Fatalf("expected not modified=%v, got modified=%v, err=%v", tc.notModified, modified, err)
+			}
+		})
+	}
+}
+
+func TestWatchStartDelay(t *testing.T) {
+	var polls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	})
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		w.WriteHeader(http.StatusNotModified)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WatchStartDelay(300*time.Millisecond), WithLogger(discard))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	defer stopWatch(a)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != 0 {
+		t.Fatalf("expected no poll before the start delay, got %d", n)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&polls) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected polls after the start delay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConditionalNotifications(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		opts        []Option
+		echo        bool
+		notModified bool
+	}{
+		{"validator echoed", []Option{ConditionalNotifications()}, true, true},
+		{"validator not echoed", []Option{ConditionalNotifications()}, false, false},
+		{"not conditional", nil, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ifNoneMatch string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ifNoneMatch = r.Header.Get("If-None-Match")
+				if tc.echo {
+					w.Header().Set("ETag", notificationsValidator(r.URL.Query().Get("notifications")))
+				}
+				_, _ = w.Write([]byte(`[{"namespaceName":"application","notificationId":1}]`))
+			}))
+			defer ts.Close()
+
+			a := InitApollo(append([]Option{Server(ts.URL), AppId("app")}, tc.opts...)...)
+			modified, err := a.getNotifications()
+			if err != nil {
+				t.Fatalf("getNotifications: %v", err)
+			}
+			if modified == tc.notModified {
+				t.Fatalf("expected not modified=%v, got modified=%v", tc.notModified, modified)
+			}
+			if conditional := ifNoneMatch != ""; conditional != (tc.opts != nil) {
+				t.Fatalf("unexpected If-None-Match %q", ifNoneMatch)
+			}
+		})
+	}
+}
+
+func TestNotificationsValidator(t *testing.T) {
+	a := notificationsValidator(`[{"namespaceName":"application","notificationId":1}]`)
+	if !strings.HasPrefix(a, `W/"`) {
+		t.Fatalf("expected a weak validator, got %s", a)
+	}
+	if b := notificationsValidator(`[{"namespaceName":"application","notificationId":1}]`); a != b {
+		t.Fatalf("expected a stable validator, got %s and %s", a, b)
+	}
+	if b := notificationsValidator(`[{"namespaceName":"application","notificationId":2}]`); a == b {
+		t.Fatal("expected the validator to change with notification ids")
+	}
+}
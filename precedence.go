@@ -0,0 +1,59 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import "strings"
+
+// LocalPrecedence makes local settings override those read from apollo, e.g.
+// to pin values while developing. By default settings read from apollo win.
+// Either way the struct interface and the remote viper agree on which value
+// wins.
+func LocalPrecedence() Option {
+	return optionFunc(func(a *Apollo) {
+		a.localFirst = true
+	})
+}
+
+// layerLocal Add local settings to the remote viper, as defaults below the
+// settings read from apollo or as overrides above them with LocalPrecedence,
+// so that it resolves keys as the struct interface does
+func (a *Apollo) layerLocal(local map[string]interface{}) {
+	keys := make(map[string]bool, len(local))
+	for k := range local {
+		keys[strings.ToLower(k)] = true
+	}
+	a.configMu.Lock()
+	a.localKeys = keys
+	a.configMu.Unlock()
+	for k, v := range local {
+		if a.localFirst {
+			Remote.Set(k, v)
+		} else {
+			Remote.SetDefault(k, v)
+		}
+	}
+}
+
+// localOnly Report whether key, a path of the remote viper, is one of the local
+// settings layered into it rather than read from apollo
+func (a *Apollo) localOnly(key string) bool {
+	key = strings.ToLower(key)
+	root := key
+	if i := strings.Index(key, "."); i >= 0 {
+		root = key[:i]
+	}
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	if !a.localKeys[root] {
+		return false
+	}
+	for k := range a.config {
+		if k = strings.ToLower(k); k == root || k == key {
+			return false
+		}
+	}
+	return true
+}
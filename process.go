@@ -0,0 +1,387 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultSecretPrefix is the prefix of values resolved by SecretResolver
+const defaultSecretPrefix = "secret://"
+
+// defaultInterpolationDepth is the maximum depth of nested ${key} references
+const defaultInterpolationDepth = 10
+
+// EnableInterpolation resolves ${key} references in configuration values
+// against other keys of the loaded configuration, once the namespaces are
+// merged so that a namespace may reference keys of another one, e.g.
+//
+//	db.url = jdbc://${db.host}:${db.port}/app
+//
+// With PrefixByNamespace, references resolve within their namespace.
+// Unresolved references are left verbatim unless StrictInterpolation is set,
+// reference cycles are always reported as errors.
+func EnableInterpolation() Option {
+	return optionFunc(func(a *Apollo) {
+		a.interpolate = true
+	})
+}
+
+// StrictInterpolation makes unresolved ${key} references an error
+func StrictInterpolation() Option {
+	return optionFunc(func(a *Apollo) {
+		a.strictInterpolation = true
+	})
+}
+
+// InterpolationMaxDepth sets the maximum depth of nested ${key} references,
+// 10 by default
+func InterpolationMaxDepth(depth int) Option {
+	return optionFunc(func(a *Apollo) {
+		a.interpolationDepth = depth
+	})
+}
+
+// KeyFilter only keeps keys accepted by filter in configurations fetched from
+// apollo, before they are merged into viper and parsed to the struct interface
+func KeyFilter(filter func(key string) bool) Option {
+	return optionFunc(func(a *Apollo) {
+		a.keyFilter = filter
+	})
+}
+
+// KeyTransformer rewrites every key of configurations fetched from apollo with
+// transform, e.g. to normalize casing, before they are filtered, merged into
+// viper and parsed to the struct interface. When several keys are rewritten to
+// the same key, the value of the greatest original key wins.
+func KeyTransformer(transform func(key string) string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.keyTransformer = transform
+	})
+}
+
+// SecretResolver resolves values referencing secrets, e.g.
+// "secret://vault/db", with resolve on every load and reload. resolve is
+// called with the whole reference. Configurations are cached to disk before
+// secrets are resolved.
+func SecretResolver(resolve func(ref string) (string, error)) Option {
+	return optionFunc(func(a *Apollo) {
+		a.secretResolver = resolve
+	})
+}
+
+// SecretPrefix sets the prefix of values resolved by SecretResolver,
+// "secret://" by default
+func SecretPrefix(prefix string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.secretPrefix = prefix
+	})
+}
+
+// TemplateValues renders string values as Go templates once configurations
+// are loaded, before they are parsed to the struct interface, e.g.
+//
+//	api.url = {{index . "api.scheme"}}://{{env "HOST"}}/api
+//
+// The data of templates is the configuration map, the namespaces merged, as
+// loaded before rendering, and env returns the value of an environment variable. Templates referencing
+// missing keys or failing to render fail the load.
+func TemplateValues() Option {
+	return optionFunc(func(a *Apollo) {
+		a.templateValues = true
+	})
+}
+
+// InferTypes converts string values which are plain numbers or booleans to
+// int, float64 or bool when configurations are loaded, e.g. "8080" to 8080 and
+// "true" to true, as properties namespaces serve every value as a string.
+// Ambiguous values stay strings: numbers with leading zeros or a plus sign,
+// integers overflowing int, and booleans spelled other than true or false.
+// Struct fields bound to inferred keys must then have a matching type.
+func InferTypes() Option {
+	return optionFunc(func(a *Apollo) {
+		a.inferTypes = true
+	})
+}
+
+// TrimValues trims the whitespace surrounding string values when
+// configurations are loaded, which would otherwise fail number or boolean
+// parsing or comparisons.
+func TrimValues() Option {
+	return optionFunc(func(a *Apollo) {
+		a.trimValues = true
+	})
+}
+
+// StripValueComments strips trailing comments from string values of
+// properties namespaces when configurations are loaded, e.g. "8080 # http"
+// becomes "8080". A comment starts with a # preceded by whitespace, so that
+// values like "#fff" or "http://host/#anchor" are kept.
+func StripValueComments() Option {
+	return optionFunc(func(a *Apollo) {
+		a.stripComments = true
+	})
+}
+
+// valueComment matches a trailing comment of a properties value
+var valueComment = regexp.MustCompile(`\s+#.*$`)
+
+// configurations Decode configurations of namespace fetched from apollo and
+// apply configured transformations
+func (a *Apollo) configurations(namespace string, b []byte) (map[string]interface{}, error) {
+	cfg, err := a.decodeConfig(b)
+	if err != nil {
+		return nil, err
+	}
+	format := a.formatOf(namespace)
+	if a.stripComments && format == "properties" {
+		for k, v := range cfg {
+			if s, ok := v.(string); ok {
+				cfg[k] = valueComment.ReplaceAllString(s, "")
+			}
+		}
+	}
+	if cfg, err = expandContent(a.sourceOf(namespace).Namespace, format, cfg); err != nil {
+		return nil, err
+	}
+	if err := a.process(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// process Apply configured transformations to configurations of a namespace
+// fetched from apollo
+func (a *Apollo) process(cfg map[string]interface{}) error {
+	if a.keyTransformer != nil {
+		a.transformKeys(cfg)
+	}
+	if a.keyFilter != nil {
+		for k := range cfg {
+			if !a.keyFilter(k) {
+				delete(cfg, k)
+			}
+		}
+	}
+	if a.trimValues {
+		for k, v := range cfg {
+			if s, ok := v.(string); ok {
+				cfg[k] = strings.TrimSpace(s)
+			}
+		}
+	}
+	if a.secretResolver != nil {
+		if err := a.resolveSecrets(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processMerged Apply configured transformations needing the whole
+// configuration to cfg, the namespaces merged, so that values may reference
+// keys of other namespaces
+func (a *Apollo) processMerged(cfg map[string]interface{}) error {
+	if a.interpolate {
+		depth := a.interpolationDepth
+		if depth <= 0 {
+			depth = defaultInterpolationDepth
+		}
+		if err := interpolate(cfg, depth, a.strictInterpolation); err != nil {
+			return err
+		}
+	}
+	if a.templateValues {
+		if err := renderTemplates(cfg); err != nil {
+			return err
+		}
+	}
+	if a.inferTypes {
+		for k, v := range cfg {
+			if s, ok := v.(string); ok {
+				cfg[k] = inferType(s)
+			}
+		}
+	}
+	if a.reconstructArrays {
+		reconstructArrays(cfg)
+	}
+	return nil
+}
+
+// templateFuncs are the functions available to templates of TemplateValues
+var templateFuncs = template.FuncMap{"env": os.Getenv}
+
+// renderTemplates Render string values of cfg holding templates, against cfg
+// as it was before rendering
+func renderTemplates(cfg map[string]interface{}) error {
+	data := make(map[string]interface{}, len(cfg))
+	for k, v := range cfg {
+		data[k] = v
+	}
+	for _, k := range sortedKeys(cfg) {
+		s, ok := cfg[k].(string)
+		if !ok || !strings.Contains(s, "{{") {
+			continue
+		}
+		t, err := template.New(k).Funcs(templateFuncs).Option("missingkey=error").Parse(s)
+		if err != nil {
+			return fmt.Errorf("failed parsing template of %s: %w", k, err)
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			return fmt.Errorf("failed rendering template of %s: %w", k, err)
+		}
+		cfg[k] = b.String()
+	}
+	return nil
+}
+
+var (
+	intPattern   = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+	floatPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)\.[0-9]+([eE][-+]?[0-9]+)?$`)
+)
+
+// inferType Return s as an int, float64 or bool if it is unambiguously one,
+// s otherwise
+func inferType(s string) interface{} {
+	switch {
+	case s == "true":
+		return true
+	case s == "false":
+		return false
+	case intPattern.MatchString(s):
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+	case floatPattern.MatchString(s):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// transformKeys Rewrite keys of cfg with the key transformer, in ascending
+// order so that colliding keys resolve the same way on every load
+func (a *Apollo) transformKeys(cfg map[string]interface{}) {
+	transformed := make(map[string]interface{}, len(cfg))
+	for _, k := range sortedKeys(cfg) {
+		transformed[a.keyTransformer(k)] = cfg[k]
+	}
+	for k := range cfg {
+		delete(cfg, k)
+	}
+	for k, v := range transformed {
+		cfg[k] = v
+	}
+}
+
+// resolveSecrets Replace values referencing secrets with the resolved secrets
+func (a *Apollo) resolveSecrets(cfg map[string]interface{}) error {
+	prefix := a.secretPrefix
+	if prefix == "" {
+		prefix = defaultSecretPrefix
+	}
+	for _, k := range sortedKeys(cfg) {
+		ref, ok := cfg[k].(string)
+		if !ok || !strings.HasPrefix(ref, prefix) {
+			continue
+		}
+		secret, err := a.secretResolver(ref)
+		if err != nil {
+			return fmt.Errorf("failed resolving secret of %s: %w", k, err)
+		}
+		cfg[k] = secret
+	}
+	return nil
+}
+
+var referencePattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// interpolator resolves ${key} references of a configuration map
+type interpolator struct {
+	cfg      map[string]interface{}
+	maxDepth int
+	strict   bool
+	resolved map[string]string
+}
+
+// interpolate Replace ${key} references of every string value in cfg
+func interpolate(cfg map[string]interface{}, maxDepth int, strict bool) error {
+	in := &interpolator{
+		cfg:      cfg,
+		maxDepth: maxDepth,
+		strict:   strict,
+		resolved: map[string]string{},
+	}
+	keys := make([]string, 0, len(cfg))
+	for k, v := range cfg {
+		if _, ok := v.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := in.resolve(k, nil)
+		if err != nil {
+			return err
+		}
+		cfg[k] = v
+	}
+	return nil
+}
+
+// resolve Return the value of key with its references resolved, chain holds
+// the keys being resolved which referenced key
+func (in *interpolator) resolve(key string, chain []string) (string, error) {
+	if v, ok := in.resolved[key]; ok {
+		return v, nil
+	}
+	for _, k := range chain {
+		if k == key {
+			return "", fmt.Errorf("failed interpolating config: reference cycle %s", strings.Join(append(chain, key), " -> "))
+		}
+	}
+	if len(chain) > in.maxDepth {
+		return "", fmt.Errorf("failed interpolating config: references of %s nested deeper than %d", chain[0], in.maxDepth)
+	}
+
+	s, ok := in.cfg[key].(string)
+	if !ok {
+		return fmt.Sprint(in.cfg[key]), nil
+	}
+	var err error
+	out := referencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		name := ref[2 : len(ref)-1]
+		if _, ok := in.cfg[name]; !ok {
+			if in.strict {
+				err = fmt.Errorf("failed interpolating config: unresolved reference %s in %s", ref, key)
+			}
+			return ref
+		}
+		v, e := in.resolve(name, append(chain, key))
+		if e != nil {
+			err = e
+			return ref
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	in.resolved[key] = out
+	return out, nil
+}
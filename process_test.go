@@ -0,0 +1,247 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	cfg := map[string]interface{}{
+		"db.host": "localhost",
+		"db.port": 5432,
+		"db.addr": "${db.host}:${db.port}",
+		"db.url":  "jdbc://${db.addr}/app",
+		"unknown": "${missing}",
+	}
+	if err := interpolate(cfg, defaultInterpolationDepth, false); err != nil {
+		t.Fatalf("interpolate: %v", err)
+	}
+	if cfg["db.url"] != "jdbc://localhost:5432/app" {
+		t.Fatalf("unexpected db.url %v", cfg["db.url"])
+	}
+	if cfg["unknown"] != "${missing}" {
+		t.Fatalf("unresolved reference should be left verbatim, got %v", cfg["unknown"])
+	}
+	if cfg["db.port"] != 5432 {
+		t.Fatalf("non string values should be kept, got %v", cfg["db.port"])
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    map[string]interface{}
+		depth  int
+		strict bool
+		want   string
+	}{
+		{"cycle", map[string]interface{}{"a": "${b}", "b": "${a}"}, 10, false, "reference cycle"},
+		{"strict", map[string]interface{}{"a": "${missing}"}, 10, true, "unresolved reference"},
+		{"depth", map[string]interface{}{"a": "${b}", "b": "${c}", "c": "${d}", "d": "x"}, 1, false, "nested deeper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := interpolate(tt.cfg, tt.depth, tt.strict)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestProcessKeyFilter(t *testing.T) {
+	a := &Apollo{keyFilter: func(key string) bool {
+		return !strings.HasPrefix(key, "ops.")
+	}}
+	cfg := map[string]interface{}{"app.name": "demo", "ops.owner": "team"}
+	if err := a.process(cfg); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if len(cfg) != 1 || cfg["app.name"] != "demo" {
+		t.Fatalf("unexpected configurations %v", cfg)
+	}
+}
+
+func TestProcessKeyTransformer(t *testing.T) {
+	a := &Apollo{
+		keyTransformer: func(key string) string {
+			return strings.ReplaceAll(strings.ToLower(key), "_", "")
+		},
+		keyFilter: func(key string) bool { return key != "ops.owner" },
+	}
+	cfg := map[string]interface{}{"maxConns": "10", "max_conns": "20", "ops.owner": "team", "retryCount": "3"}
+	if err := a.process(cfg); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	want := map[string]interface{}{"maxconns": "20", "retrycount": "3"}
+	if len(cfg) != len(want) {
+		t.Fatalf("unexpected configurations %v", cfg)
+	}
+	for k, v := range want {
+		if cfg[k] != v {
+			t.Fatalf("expected %s=%v, got %v", k, v, cfg[k])
+		}
+	}
+}
+
+func TestProcessSecretResolver(t *testing.T) {
+	a := &Apollo{secretResolver: func(ref string) (string, error) {
+		if ref != "secret://vault/db" {
+			return "", errors.New("unknown secret")
+		}
+		return "p4ss", nil
+	}}
+	cfg := map[string]interface{}{"db.password": "secret://vault/db", "db.user": "app"}
+	if err := a.process(cfg); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if cfg["db.password"] != "p4ss" || cfg["db.user"] != "app" {
+		t.Fatalf("unexpected configurations %v", cfg)
+	}
+
+	cfg = map[string]interface{}{"api.token": "secret://vault/api"}
+	if err := a.process(cfg); err == nil || !strings.Contains(err.Error(), "api.token") {
+		t.Fatalf("expected an error naming the key, got %v", err)
+	}
+}
+
+func TestInferType(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"8080", 8080},
+		{"-3", -3},
+		{"0", 0},
+		{"1.5", 1.5},
+		{"-2.5e3", -2500.0},
+		{"true", true},
+		{"false", false},
+		{"007", "007"},
+		{"+1", "+1"},
+		{"1.", "1."},
+		{"99999999999999999999", "99999999999999999999"},
+		{"True", "True"},
+		{"yes", "yes"},
+		{"", ""},
+		{"8080 ", "8080 "},
+	}
+	for _, tc := range tests {
+		if got := inferType(tc.in); got != tc.want {
+			t.Errorf("inferType(%q) = %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// processed Decode configurations of namespace as load does for a single
+// namespace
+func processed(a *Apollo, namespace string, b []byte) (map[string]interface{}, error) {
+	cfg, err := a.configurations(namespace, b)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, a.processMerged(cfg)
+}
+
+func TestInferTypesOnLoad(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), InferTypes())
+	cfg, err := processed(a, "application", []byte(`{"port":"8080","debug":"true","name":"app"}`))
+	if err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	if cfg["port"] != 8080 || cfg["debug"] != true || cfg["name"] != "app" {
+		t.Fatalf("unexpected configurations %#v", cfg)
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	if err := os.Setenv("VAPOLLO_TEST_HOST", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("VAPOLLO_TEST_HOST")
+
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), TemplateValues())
+	cfg, err := processed(a, "application", []byte(`{"scheme":"https","api.url":"{{.scheme}}://{{env \"VAPOLLO_TEST_HOST\"}}/api","port":"{{index . \"api.port\"}}","api.port":"443"}`))
+	if err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	if cfg["api.url"] != "https://example.com/api" || cfg["port"] != "443" {
+		t.Fatalf("unexpected configurations %v", cfg)
+	}
+
+	for _, value := range []string{`{{.missing}}`, `{{.scheme`} {
+		_, err := processed(a, "application", []byte(`{"scheme":"https","bad":"`+value+`"}`))
+		if err == nil || !strings.Contains(err.Error(), "template of bad") {
+			t.Fatalf("%s: expected a template error naming the key, got %v", value, err)
+		}
+	}
+}
+
+func TestTrimValues(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), TrimValues(), StripValueComments(), InferTypes())
+	cfg, err := processed(a, "application", []byte(`{"port":" 8080 # http ","color":"#fff","url":"http://host/#top","name":" app "}`))
+	if err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	if cfg["port"] != 8080 || cfg["color"] != "#fff" || cfg["url"] != "http://host/#top" || cfg["name"] != "app" {
+		t.Fatalf("unexpected configurations %#v", cfg)
+	}
+
+	cfg, err = processed(a, "app.json", []byte(`{"content":"{\"motd\":\"hello # world\"}"}`))
+	if err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	if cfg["motd"] != "hello # world" {
+		t.Fatalf("expected comments kept outside properties namespaces, got %#v", cfg)
+	}
+}
+
+func TestReconstructArrays(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"), ReconstructArrays(), InferTypes())
+	cfg, err := processed(a, "application", []byte(`{
+		"hosts.0":"a","hosts.2":"c","hosts.1":"b",
+		"db.0.host":"x","db.0.port":"1","db.0.tls.cert":"pem","db.1":"y",
+		"name":"app","name.0":"kept",
+		"mixed.0":"s","mixed.0.k":"v",
+		"v.01":"not an index"}`))
+	if err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	want := map[string]interface{}{
+		"hosts": []interface{}{"a", "b", "c"},
+		"db": []interface{}{
+			map[string]interface{}{"host": "x", "port": 1, "tls": map[string]interface{}{"cert": "pem"}},
+			"y",
+		},
+		"name":      "app",
+		"name.0":    "kept",
+		"mixed.0":   "s",
+		"mixed.0.k": "v",
+		"v.01":      "not an index",
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("unexpected configurations %#v", cfg)
+	}
+
+	var bound struct {
+		Hosts []string `mapstructure:"hosts"`
+	}
+	a = InitApollo(Server("http://127.0.0.1"), AppId("app"), Struct(&bound), ReconstructArrays(), WithLogger(discard))
+	if cfg, err = processed(a, "application", []byte(`{"hosts.0":"a","hosts.5":"b"}`)); err != nil {
+		t.Fatalf("processed: %v", err)
+	}
+	if err := a.ParseStruct(nil, cfg); err != nil {
+		t.Fatalf("ParseStruct: %v", err)
+	}
+	if !reflect.DeepEqual(bound.Hosts, []string{"a", "b"}) {
+		t.Fatalf("expected sparse indices compacted, got %v", bound.Hosts)
+	}
+}
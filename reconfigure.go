@@ -0,0 +1,107 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Reconfigure points the instance at another apollo server or cluster at
+// runtime, e.g. to fail over to another datacenter. Only the Server,
+// DiscoverSRV and Cluster options are accepted, any other option fails, AppId
+// included. Namespaces set by Sources keep their cluster.
+//
+// The new target is probed by reading the first namespace before switching,
+// the instance is left untouched and keeps its configuration if it doesn't
+// answer. A running watcher is stopped, waiting for its pending notification
+// poll, and restarted against the new target, which then delivers its
+// configuration as modifications are delivered.
+func (a *Apollo) Reconfigure(opts ...Option) error {
+	target, err := reconfiguration(opts)
+	if err != nil {
+		return err
+	}
+	if a.static != nil {
+		return errors.New("failed reconfiguring apollo: static configuration has no target")
+	}
+	if target.appID != "" && target.appID != a.appID {
+		return errors.New("failed reconfiguring apollo: appId can't be changed")
+	}
+
+	a.watchMu.Lock()
+	watching, stopped := a.quit != nil, a.stopped
+	a.watchMu.Unlock()
+	if watching {
+		a.StopWatch()
+		<-stopped
+	}
+
+	a.applyMu.Lock()
+	previous := a.swapTarget(target)
+	if len(a.servers) == 0 {
+		err = errors.New("no server resolved")
+	} else {
+		_, err = a.fetchNamespace(a.namespaceName)
+	}
+	if err != nil {
+		a.swapTarget(previous)
+		a.applyMu.Unlock()
+		if watching {
+			_ = a.StartWatch()
+		}
+		return fmt.Errorf("failed reconfiguring apollo, keeping the current target: %w", err)
+	}
+	// notification ids of the new target are unrelated, the first poll
+	// reports every namespace as modified
+	for i := range a.notifications {
+		a.notifications[i].NotificationID = -1
+	}
+	a.applyMu.Unlock()
+	a.logger.Printf("Apollo reconfigured: %s", a.summary())
+
+	if watching {
+		return a.StartWatch()
+	}
+	a.applyChange()
+	return nil
+}
+
+// reconfiguration Return the options of Reconfigure applied to an empty
+// instance, failing if they set anything but the target
+func reconfiguration(opts []Option) (*Apollo, error) {
+	target := &Apollo{}
+	for _, opt := range opts {
+		opt.apply(target)
+	}
+	allowed := &Apollo{server: target.server, srvService: target.srvService, cluster: target.cluster, appID: target.appID}
+	if !reflect.DeepEqual(target, allowed) {
+		return nil, errors.New("failed reconfiguring apollo: only Server, DiscoverSRV and Cluster can be changed")
+	}
+	return target, nil
+}
+
+// swapTarget Point the instance at the server and cluster set in target,
+// keeping the current ones when not set, and return the previous target. A
+// previous target, whose servers are resolved, is restored as is.
+func (a *Apollo) swapTarget(target *Apollo) *Apollo {
+	a.serverMu.Lock()
+	defer a.serverMu.Unlock()
+	previous := &Apollo{server: a.server, servers: a.servers, srvService: a.srvService, cluster: a.cluster}
+	if target.cluster != "" {
+		a.cluster = target.cluster
+	}
+	if target.servers != nil {
+		a.server, a.servers, a.srvService = target.server, target.servers, target.srvService
+		return previous
+	}
+	if target.server != "" || target.srvService != "" {
+		a.server, a.srvService = target.server, target.srvService
+		a.resolveServers()
+	}
+	return previous
+}
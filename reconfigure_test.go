@@ -0,0 +1,92 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconfigure(t *testing.T) {
+	primary := newFakeApollo(`{"dc":"primary"}`)
+	defer primary.Close()
+	dr := newFakeApollo(`{"dc":"dr"}`)
+	defer dr.Close()
+	down := newFakeApollo(`{}`)
+	down.Close()
+
+	a := InitApollo(Server(primary.URL), AppId("app"), WithLogger(discard))
+	useRemote(t, a)
+	if err := Remote.ReadRemoteConfig(); err != nil {
+		t.Fatalf("ReadRemoteConfig: %v", err)
+	}
+
+	if err := a.Reconfigure(AppId("other")); err == nil {
+		t.Fatal("expected changing the appId to fail")
+	}
+	if err := a.Reconfigure(Server(dr.URL), WithLogger(discard)); err == nil {
+		t.Fatal("expected options other than the target to fail")
+	}
+	if err := a.Reconfigure(Server(down.URL)); err == nil {
+		t.Fatal("expected an unreachable target to fail")
+	}
+	if a.currentServer() != primary.URL || Remote.GetString("dc") != "primary" {
+		t.Fatalf("expected the current target and configuration kept, got %s and %q", a.currentServer(), Remote.GetString("dc"))
+	}
+
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	defer stopWatch(a)
+	if err := a.Reconfigure(Server(dr.URL), Cluster("dr")); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if a.currentServer() != dr.URL || a.sourceOf("application").Cluster != "dr" {
+		t.Fatalf("expected the new target, got %s", a.currentServer())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for a.configCopy()["dc"] != "dr" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the configuration of the new target, got %v", a.configCopy())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconfigureConcurrentReads(t *testing.T) {
+	primary := newFakeApollo(`{"dc":"primary"}`)
+	defer primary.Close()
+	dr := newFakeApollo(`{"dc":"dr"}`)
+	defer dr.Close()
+
+	a := InitApollo(Server(primary.URL), AppId("app"), WithLogger(discard))
+	useRemote(t, a)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, _ = a.RawFile("application")
+			_ = a.summary()
+			_ = a.notificationGroups()
+		}
+	}()
+	for i, target := range []string{dr.URL, primary.URL, dr.URL} {
+		if err := a.Reconfigure(Server(target), Cluster([]string{"dr", "default"}[i%2])); err != nil {
+			t.Fatalf("Reconfigure: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,133 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrReleaseHistoryUnavailable is returned by ReleaseHistory when no portal
+// was set by Portal or the portal doesn't serve the release history
+var ErrReleaseHistoryUnavailable = errors.New("apollo release history unavailable")
+
+// releaseTimeLayout is the layout of the release times served by the portal
+const releaseTimeLayout = "2006-01-02 15:04:05"
+
+// Release describes a release of a namespace
+type Release struct {
+	Namespace string
+	ID        int64
+	Title     string
+	Comment   string
+	// Author is the user who released the namespace
+	Author string
+	Time   time.Time
+	// Keys are the keys of the namespace as released
+	Keys []string
+}
+
+// releaseHistory is a release as served by the portal
+type releaseHistory struct {
+	ReleaseID            int64  `json:"releaseId"`
+	ReleaseTitle         string `json:"releaseTitle"`
+	ReleaseComment       string `json:"releaseComment"`
+	Operator             string `json:"operator"`
+	ReleaseTimeFormatted string `json:"releaseTimeFormatted"`
+	Configuration        []struct {
+		Key string `json:"firstEntity"`
+	} `json:"configuration"`
+}
+
+// Portal sets the address of the apollo portal and the env of the config
+// service in the portal, e.g. DEV, which ReleaseHistory and KeyMetadata read
+// from.
+// Requests to the portal are signed and mutated like requests to apollo, use
+// RequestMutator to authenticate them.
+func Portal(addr, env string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.portal = strings.TrimSuffix(addr, "/")
+		a.portalEnv = env
+	})
+}
+
+// ReleaseHistory returns the limit most recent releases of the watched
+// namespaces, the latest first, as served by the portal set by Portal. It
+// returns ErrReleaseHistoryUnavailable if no portal was set or the portal
+// doesn't serve the release history, e.g. an older version or a missing
+// permission, so that callers can hide the history rather than fail.
+func (a *Apollo) ReleaseHistory(limit int) ([]Release, error) {
+	if a.portal == "" {
+		return nil, ErrReleaseHistoryUnavailable
+	}
+	var releases []Release
+	for _, namespace := range a.namespaces {
+		history, err := a.namespaceReleases(namespace, limit)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, history...)
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].Time.After(releases[j].Time)
+	})
+	if limit > 0 && len(releases) > limit {
+		releases = releases[:limit]
+	}
+	return releases, nil
+}
+
+// namespaceReleases Read the limit most recent releases of namespace from
+// the portal
+func (a *Apollo) namespaceReleases(namespace string, limit int) ([]Release, error) {
+	s := a.sourceOf(namespace)
+	uri := fmt.Sprintf("%s/apps/%s/envs/%s/clusters/%s/namespaces/%s/releases/histories?page=0&size=%d",
+		a.portal, url.PathEscape(s.AppID), url.PathEscape(a.portalEnv),
+		url.PathEscape(s.Cluster), url.PathEscape(s.Namespace), limit)
+	resp, err := a.send(a.baseCtx, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed:
+		return nil, fmt.Errorf("%w: status %d", ErrReleaseHistoryUnavailable, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("failed reading release history of %s: status %d", namespace, resp.StatusCode)
+	}
+	b, err := a.readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var history []releaseHistory
+	if err := a.json().Unmarshal(b, &history); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReleaseHistoryUnavailable, newInvalidResponse(resp, b, err))
+	}
+
+	releases := make([]Release, 0, len(history))
+	for _, h := range history {
+		r := Release{
+			Namespace: namespace,
+			ID:        h.ReleaseID,
+			Title:     h.ReleaseTitle,
+			Comment:   h.ReleaseComment,
+			Author:    h.Operator,
+		}
+		r.Time, _ = time.ParseInLocation(releaseTimeLayout, h.ReleaseTimeFormatted, time.Local)
+		for _, entry := range h.Configuration {
+			r.Keys = append(r.Keys, entry.Key)
+		}
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
@@ -0,0 +1,49 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReleaseHistory(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app/envs/DEV/clusters/default/namespaces/application/releases/histories":
+			_, _ = w.Write([]byte(`[{"releaseId":2,"releaseTitle":"r2","releaseComment":"bump port","operator":"alice",` +
+				`"releaseTimeFormatted":"2022-05-02 10:00:00","configuration":[{"firstEntity":"port","secondEntity":"8081"}]}]`))
+		case "/apps/app/envs/DEV/clusters/default/namespaces/db/releases/histories":
+			_, _ = w.Write([]byte(`[{"releaseId":1,"releaseTitle":"r1","operator":"bob","releaseTimeFormatted":"2022-05-01 10:00:00"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "db"), Portal(ts.URL, "DEV"), WithLogger(discard))
+	releases, err := a.ReleaseHistory(10)
+	if err != nil {
+		t.Fatalf("ReleaseHistory: %v", err)
+	}
+	if len(releases) != 2 || releases[0].ID != 2 || releases[0].Author != "alice" || releases[0].Keys[0] != "port" || releases[1].Namespace != "db" {
+		t.Fatalf("unexpected releases %+v", releases)
+	}
+	if releases, _ = a.ReleaseHistory(1); len(releases) != 1 {
+		t.Fatalf("expected the history limited to 1 release, got %d", len(releases))
+	}
+
+	a = InitApollo(Server(ts.URL), AppId("other"), Portal(ts.URL, "DEV"), WithLogger(discard))
+	if _, err := a.ReleaseHistory(10); !errors.Is(err, ErrReleaseHistoryUnavailable) {
+		t.Fatalf("expected ErrReleaseHistoryUnavailable on 404, got %v", err)
+	}
+	a = InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	if _, err := a.ReleaseHistory(10); !errors.Is(err, ErrReleaseHistoryUnavailable) {
+		t.Fatalf("expected ErrReleaseHistoryUnavailable without portal, got %v", err)
+	}
+}
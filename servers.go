@@ -0,0 +1,90 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// lookupSRV resolves DNS SRV records, replaced in tests
+var lookupSRV = net.LookupSRV
+
+// DiscoverSRV resolves apollo config services from the DNS SRV records of
+// service, e.g. "_apollo._tcp.example.com". Resolved services are tried in
+// order of priority, followed by the server set by Server, which is the only
+// one used if the resolution fails.
+func DiscoverSRV(service string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.srvService = service
+	})
+}
+
+// resolveServers Build the pool of servers requests are sent to, the first
+// one being active
+func (a *Apollo) resolveServers() {
+	var servers []string
+	if a.srvService != "" {
+		_, addrs, err := lookupSRV("", "", a.srvService)
+		if err != nil {
+			a.logger.Printf("Failed resolving SRV records of %s: %v", a.srvService, err)
+		}
+		for _, srv := range addrs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			servers = append(servers, "http://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	}
+	if a.server != "" {
+		servers = append(servers, normalizeServer(a.server))
+	}
+	a.servers = servers
+	if len(servers) > 0 {
+		a.server = servers[0]
+	}
+}
+
+// normalizeServer Add the http scheme to server if missing
+func normalizeServer(server string) string {
+	if !strings.Contains(server, "http") && !strings.Contains(server, "https") {
+		return "http://" + server
+	}
+	return server
+}
+
+// currentServer Return the server requests are sent to first
+func (a *Apollo) currentServer() string {
+	a.serverMu.RLock()
+	defer a.serverMu.RUnlock()
+	return a.server
+}
+
+// currentCluster Return the cluster configurations are read from
+func (a *Apollo) currentCluster() string {
+	a.serverMu.RLock()
+	defer a.serverMu.RUnlock()
+	return a.cluster
+}
+
+// serverPool Return the servers to try in order, the active one first
+func (a *Apollo) serverPool() []string {
+	a.serverMu.RLock()
+	defer a.serverMu.RUnlock()
+	current := a.server
+	pool := []string{current}
+	for _, s := range a.servers {
+		if s != current {
+			pool = append(pool, s)
+		}
+	}
+	return pool
+}
+
+func (a *Apollo) setServer(server string) {
+	a.serverMu.Lock()
+	a.server = server
+	a.serverMu.Unlock()
+}
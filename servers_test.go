@@ -0,0 +1,53 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDiscoverSRV(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+
+	defer func(lookup func(string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = lookup
+	}(lookupSRV)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{
+			{Target: "127.0.0.1.", Port: 1},
+			{Target: host + ".", Port: uint16(p)},
+		}, nil
+	}
+
+	a := InitApollo(DiscoverSRV("_apollo._tcp.example.com"), Server("10.0.0.1:8080"), AppId("app"))
+	want := []string{"http://127.0.0.1:1", ts.URL, "http://10.0.0.1:8080"}
+	if !reflect.DeepEqual(a.servers, want) {
+		t.Fatalf("unexpected servers %v", a.servers)
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("expected failover to the second server, got %v", err)
+	}
+	if got := a.currentServer(); got != ts.URL {
+		t.Fatalf("expected %s active, got %s", ts.URL, got)
+	}
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("no such host")
+	}
+	a = InitApollo(DiscoverSRV("_apollo._tcp.example.com"), Server("10.0.0.1:8080"), AppId("app"), WithLogger(discard))
+	if !reflect.DeepEqual(a.servers, []string{"http://10.0.0.1:8080"}) {
+		t.Fatalf("expected fallback to the explicit server, got %v", a.servers)
+	}
+}
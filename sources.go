@@ -0,0 +1,144 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"context"
+	"strings"
+)
+
+// Source is a namespace of any apollo app and cluster, e.g. shared
+// configuration of a platform app read along with the app's own
+type Source struct {
+	// AppID is the app of the namespace, the one set by AppId if empty
+	AppID string
+	// Cluster is the cluster of the namespace, the one set by Cluster if
+	// empty
+	Cluster string
+	// Namespace is the name of the namespace, "application" if empty
+	Namespace string
+}
+
+// Sources sets the namespaces loaded from apollo as namespaces of any app and
+// cluster, replacing Namespaces. Their configurations are merged, earlier
+// sources taking precedence over later ones as with Namespaces.
+//
+// Sources of the app and cluster set by AppId and Cluster are named after
+// their namespace, e.g. for Viper or PrefixByNamespace, and the others
+// appId+cluster+namespace. The access key, if any, signs requests of every
+// app, which must share it or not require one.
+func Sources(sources ...Source) Option {
+	return optionFunc(func(a *Apollo) {
+		a.sourceList = sources
+	})
+}
+
+// initSources Resolve the namespaces of sources set by Sources, if any
+func (a *Apollo) initSources() {
+	if len(a.sourceList) == 0 {
+		return
+	}
+	a.sources = map[string]Source{}
+	a.namespaces = nil
+	for _, s := range a.sourceList {
+		if s.AppID == "" {
+			s.AppID = a.appID
+		}
+		if s.Cluster == "" {
+			s.Cluster = a.cluster
+		}
+		if s.Namespace == "" {
+			s.Namespace = "application"
+		}
+		name := s.Namespace
+		if s.AppID != a.appID || s.Cluster != a.cluster {
+			name = strings.Join([]string{s.AppID, s.Cluster, s.Namespace}, "+")
+		}
+		a.sources[name] = s
+		a.namespaces = append(a.namespaces, name)
+	}
+}
+
+// sourceOf Return the app, cluster and name of namespace
+func (a *Apollo) sourceOf(namespace string) Source {
+	if s, ok := a.sources[namespace]; ok {
+		return s
+	}
+	return Source{AppID: a.appID, Cluster: a.currentCluster(), Namespace: namespace}
+}
+
+// notificationGroup is the notifications of the namespaces of an app and
+// cluster, watched by one long-poll request
+type notificationGroup struct {
+	appID   string
+	cluster string
+	// notifications are named after the namespaces of the app, names maps
+	// them back to the namespaces of this instance
+	notifications []notification
+	names         map[string]string
+}
+
+// notificationGroups Group the notifications by app and cluster
+func (a *Apollo) notificationGroups() []*notificationGroup {
+	var groups []*notificationGroup
+	byCoordinates := map[string]*notificationGroup{}
+	for _, n := range a.notifications {
+		s := a.sourceOf(n.NamespaceName)
+		coordinates := s.AppID + "+" + s.Cluster
+		g, ok := byCoordinates[coordinates]
+		if !ok {
+			g = &notificationGroup{appID: s.AppID, cluster: s.Cluster, names: map[string]string{}}
+			byCoordinates[coordinates] = g
+			groups = append(groups, g)
+		}
+		g.names[s.Namespace] = n.NamespaceName
+		g.notifications = append(g.notifications, notification{
+			NamespaceName:  s.Namespace,
+			NotificationID: n.NotificationID,
+		})
+	}
+	return groups
+}
+
+// pollGroups Long-poll the notifications of every group concurrently,
+// returning once any group reports modifications or all returned
+func (a *Apollo) pollGroups(groups []*notificationGroup) (bool, error) {
+	ctx, cancel := context.WithCancel(a.baseCtx)
+	defer cancel()
+
+	type result struct {
+		changed []notification
+		err     error
+	}
+	results := make(chan result, len(groups))
+	for _, g := range groups {
+		go func(g *notificationGroup) {
+			changed, err := a.pollNotifications(ctx, g)
+			if changed != nil {
+				cancel()
+			}
+			results <- result{changed, err}
+		}(g)
+	}
+
+	var (
+		modified bool
+		firstErr error
+	)
+	for range groups {
+		r := <-results
+		if r.changed != nil {
+			modified = true
+			a.updateNotifications(r.changed)
+		} else if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	if modified {
+		return true, nil
+	}
+	return false, firstErr
+}
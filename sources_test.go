@@ -0,0 +1,84 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSources(t *testing.T) {
+	configs := map[string]string{
+		"/configs/app/default/application":      `{"a":"app"}`,
+		"/configs/platform/default/application": `{"a":"platform","b":"platform"}`,
+		"/configs/platform/prod/common":         `{"b":"prod","c":"prod"}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, ok := configs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"configurations":` + cfg + `,"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Sources(
+		Source{},
+		Source{AppID: "platform"},
+		Source{AppID: "platform", Cluster: "prod", Namespace: "common"},
+	))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := map[string]string{"a": "app", "b": "platform", "c": "prod"}
+	for k, v := range want {
+		if got := a.config[k]; got != v {
+			t.Fatalf("expected %s=%s, got %v", k, v, got)
+		}
+	}
+	if v := a.Viper("platform+prod+common"); v == nil || v.GetString("c") != "prod" {
+		t.Fatalf("expected a viper for platform+prod+common")
+	}
+}
+
+func TestSourcesNotifications(t *testing.T) {
+	polls := make(chan string, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID := r.URL.Query().Get("appId")
+		polls <- appID + ":" + r.URL.Query().Get("notifications")
+		if appID == "platform" {
+			_, _ = w.Write([]byte(`[{"namespaceName":"common","notificationId":7}]`))
+			return
+		}
+		// the poll of app is held until the platform poll returns
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Sources(Source{}, Source{AppID: "platform", Namespace: "common"}))
+	modified, err := a.getNotifications()
+	if err != nil || !modified {
+		t.Fatalf("expected modified notifications, got %v, %v", modified, err)
+	}
+	for _, n := range a.notifications {
+		if n.NamespaceName == "platform+default+common" && n.NotificationID != 7 {
+			t.Fatalf("expected the notification id of platform+default+common to be updated, got %d", n.NotificationID)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if poll := <-polls; strings.HasPrefix(poll, "platform:") && !strings.Contains(poll, `"namespaceName":"common"`) {
+			t.Fatalf("expected namespaces named as in their app, got %s", poll)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import "time"
+
+// StaleAfter reports the configuration as stale when no notification poll
+// succeeded, whether reporting modifications or not, for window: a warning is
+// logged and the StaleConfig hook of the Observer is called. It detects
+// watchers silently stuck, e.g. on a long-poll a proxy dropped, which fail no
+// request. The configuration is reported stale once, then again only after a
+// poll succeeded.
+func StaleAfter(window time.Duration) Option {
+	return optionFunc(func(a *Apollo) {
+		a.staleAfter = window
+	})
+}
+
+// watchStale Report the configuration as stale whenever no poll succeeded for
+// the stale window, until quit
+func (a *Apollo) watchStale(quit chan bool, done <-chan struct{}) {
+	start := time.Now()
+	var reported time.Time
+	timer := time.NewTimer(a.staleAfter)
+	defer timer.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		a.statsMu.Lock()
+		last := a.lastSuccess
+		a.statsMu.Unlock()
+		if last.Before(start) {
+			last = start
+		}
+
+		since := time.Since(last)
+		wait := a.staleAfter - since
+		if wait <= 0 {
+			if !reported.Equal(last) {
+				reported = last
+				a.reportStale(since)
+			}
+			wait = a.staleAfter
+		}
+		timer.Reset(wait)
+	}
+}
+
+// reportStale Log the configuration as stale and notify the observer
+func (a *Apollo) reportStale(since time.Duration) {
+	a.logger.Printf("Apollo configuration may be stale: no notification poll succeeded for %v", since.Round(time.Millisecond))
+	if a.observer.StaleConfig != nil {
+		a.safely("StaleConfig hook", func() { a.observer.StaleConfig(since) })
+	}
+}
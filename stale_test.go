@@ -0,0 +1,49 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleAfter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{},"releaseKey":"r1"}`))
+	})
+	// the long-poll never returns, as behind a proxy which dropped it
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stale int32
+	a := InitApollo(Server(ts.URL), AppId("app"), BaseContext(ctx), WithLogger(discard),
+		StaleAfter(50*time.Millisecond),
+		WithObserver(Observer{StaleConfig: func(since time.Duration) {
+			if since < 50*time.Millisecond {
+				t.Errorf("reported stale after %v only", since)
+			}
+			atomic.AddInt32(&stale, 1)
+		}}))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	stopWatch(a)
+	if got := atomic.LoadInt32(&stale); got != 1 {
+		t.Fatalf("expected the configuration reported stale once, got %d", got)
+	}
+}
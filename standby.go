@@ -0,0 +1,55 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+// Standby starts the client in warm standby, e.g. for blue/green: the watcher
+// keeps polling, fetching and parsing configuration, which Keys and the disk
+// cache reflect, but it is applied to the remote viper, the struct interface
+// and callbacks only once Promote is called.
+func Standby() Option {
+	return optionFunc(func(a *Apollo) {
+		a.standby = true
+	})
+}
+
+// Promote leaves standby, applying the latest configuration fetched while in
+// standby without reaching apollo again. It does nothing if not in standby.
+func (a *Apollo) Promote() {
+	a.standbyMu.Lock()
+	warm := a.warm
+	a.standby, a.warm = false, nil
+	a.promoted = warm
+	a.standbyMu.Unlock()
+	if warm != nil {
+		a.applyChange()
+	}
+}
+
+// warmUp Load configuration without applying it if in standby, report
+// whether in standby
+func (a *Apollo) warmUp() (bool, error) {
+	a.standbyMu.Lock()
+	defer a.standbyMu.Unlock()
+	if !a.standby {
+		return false, nil
+	}
+	b, err := a.load()
+	if err != nil {
+		return true, err
+	}
+	a.warm = b
+	return true, nil
+}
+
+// takePromoted Return the configuration promoted from standby once, nil if
+// none is waiting to be applied
+func (a *Apollo) takePromoted() []byte {
+	a.standbyMu.Lock()
+	defer a.standbyMu.Unlock()
+	b := a.promoted
+	a.promoted = nil
+	return b
+}
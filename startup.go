@@ -0,0 +1,100 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"strings"
+)
+
+// StrictStartup makes InitViperRemote fail unless the initial load is
+// flawless: besides the errors failing it anyway, namespaces skipped by
+// BestEffortLoad, configuration read from the disk cache and settings failing
+// to decode into the struct interface or subtree bindings fail it, rather than
+// being logged. All of them are returned together in a StartupError, and the
+// watcher isn't started.
+func StrictStartup() Option {
+	return optionFunc(func(a *Apollo) {
+		a.strictStartup = true
+	})
+}
+
+// StartupError is returned by InitViperRemote with StrictStartup, holding
+// every error of the initial load
+type StartupError struct {
+	Errors []error
+}
+
+func (e *StartupError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "apollo startup failed: " + strings.Join(msgs, "; ")
+}
+
+// Is Report whether any of the errors matches target
+func (e *StartupError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// strictStart Start as start does, failing on any error of the initial load
+func (a *Apollo) strictStart() error {
+	a.startupMu.Lock()
+	a.startupErrs = []error{}
+	a.startupMu.Unlock()
+	defer func() {
+		a.startupMu.Lock()
+		a.startupErrs = nil
+		a.startupMu.Unlock()
+	}()
+
+	if err := a.firstLoad(); err != nil {
+		a.startupError(err)
+		return a.takeStartupErrors()
+	}
+	if a.Source() == SourceCache {
+		a.startupError(errors.New("configuration read from the disk cache, apollo unreachable"))
+	}
+	if a.afterReload != nil {
+		a.safely("AfterReload hook", func() { a.afterReload(Remote) })
+	}
+	a.bindRemote()
+	if err := a.takeStartupErrors(); err != nil {
+		return err
+	}
+	_ = Remote.WatchRemoteConfigOnChannel()
+	a.logger.Printf("Apollo remote initialized: %s", a.summary())
+	a.logEffectiveConfig()
+	return nil
+}
+
+// startupError Record err if the initial load is checked by StrictStartup
+func (a *Apollo) startupError(err error) {
+	a.startupMu.Lock()
+	defer a.startupMu.Unlock()
+	if a.startupErrs != nil {
+		a.startupErrs = append(a.startupErrs, err)
+	}
+}
+
+// takeStartupErrors Return the recorded errors as a StartupError, nil if none
+// was recorded
+func (a *Apollo) takeStartupErrors() error {
+	a.startupMu.Lock()
+	defer a.startupMu.Unlock()
+	if len(a.startupErrs) == 0 {
+		return nil
+	}
+	err := &StartupError{Errors: a.startupErrs}
+	a.startupErrs = []error{}
+	return err
+}
@@ -0,0 +1,53 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestStrictStartup(t *testing.T) {
+	s := &namespaceServer{configs: map[string]string{
+		"good": `{"port":"abc"}`,
+		"bad":  `not json`,
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var cfg struct {
+		Port int `mapstructure:"port"`
+	}
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("good", "bad"), BestEffortLoad(),
+		Struct(&cfg), IgnoreLocalSettings(), StrictStartup(), WithLogger(discard))
+	_, err := InitViperRemote(a, viper.KeyDelimiter(":"))
+	defer stopWatch(a)
+	var startupErr *StartupError
+	if !errors.As(err, &startupErr) || len(startupErr.Errors) != 2 {
+		t.Fatalf("expected the skipped namespace and the decode error, got %v", err)
+	}
+	a.watchMu.Lock()
+	watching := a.quit != nil
+	a.watchMu.Unlock()
+	if watching {
+		t.Fatal("expected the watcher not started")
+	}
+
+	s.set("good", `{"port":"8080"}`)
+	s.set("bad", `{}`)
+	a = InitApollo(Server(ts.URL), AppId("app"), Namespaces("good", "bad"), BestEffortLoad(),
+		Struct(&cfg), IgnoreLocalSettings(), StrictStartup(), WithLogger(discard))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	stopWatch(a)
+	if cfg.Port != 8080 {
+		t.Fatalf("expected the struct decoded, got port %d", cfg.Port)
+	}
+}
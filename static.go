@@ -0,0 +1,62 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+
+	"github.com/spf13/viper"
+)
+
+// InitStatic initiate apollo with the static configuration cfg instead of
+// reading it from apollo, e.g. for offline development or tests. The remote
+// viper, the struct interface and the other read APIs are set up as with
+// configuration read from apollo, with the same transformations applied, but
+// nothing is watched and no request is sent. Server and AppId are not needed.
+func InitStatic(cfg map[string]interface{}, opts ...Option) *Apollo {
+	apollo := newApollo(opts...)
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		log.Panicln("Can't not init static apollo, invalid configuration: ", err)
+		return nil
+	}
+	apollo.static = b
+	apollo.initNamespaces()
+	apollo.snapshotStructDefaults()
+
+	if b, err = apollo.load(); err == nil {
+		Remote = viper.NewWithOptions(viper.KeyDelimiter(":"))
+		Remote.SetConfigType("json")
+		err = Remote.ReadConfig(bytes.NewReader(b))
+	}
+	if err == nil && apollo.onFirstLoad != nil {
+		err = apollo.onFirstLoad(apollo.configCopy())
+	}
+	if err != nil {
+		log.Panicln("Can't not init static apollo: ", err)
+		return nil
+	}
+	apollo.bindRemote()
+	setInitStatus(apollo, "")
+	return apollo
+}
+
+// loadStatic Load the static configuration as configurations of namespace,
+// the first namespace holds it and the others are empty
+func (a *Apollo) loadStatic(namespace string) (*namespaceLoad, error) {
+	l := &namespaceLoad{namespace: namespace, source: SourceStatic, content: []byte("{}")}
+	if namespace == a.namespaceName {
+		l.content = a.static
+	}
+	cfg, err := a.configurations(namespace, l.content)
+	if err != nil {
+		return nil, err
+	}
+	l.cfg = cfg
+	return l, nil
+}
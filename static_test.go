@@ -0,0 +1,34 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInitStatic(t *testing.T) {
+	var cfg struct {
+		Port int    `mapstructure:"port"`
+		Name string `mapstructure:"name"`
+	}
+	defer setInitStatus(nil, "")
+	a := InitStatic(map[string]interface{}{"port": "8080", "name": "app"},
+		Struct(&cfg), KeyTransformer(strings.ToLower), WithLogger(discard))
+
+	if cfg.Port != 8080 || cfg.Name != "app" {
+		t.Fatalf("unexpected struct %+v", cfg)
+	}
+	if got := Remote.GetString("name"); got != "app" {
+		t.Fatalf("expected remote viper to hold name=app, got %q", got)
+	}
+	if got := a.Source(); got != SourceStatic {
+		t.Fatalf("expected source %s, got %s", SourceStatic, got)
+	}
+	if err := a.StartWatch(); err == nil {
+		t.Fatalf("expected static configuration not to be watched")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"expvar"
+	"time"
+)
+
+// WatchStats is the cumulative activity of the watcher, e.g. to publish with
+// expvar:
+//
+//	expvar.Publish("apollo", expvar.Func(func() interface{} { return apollo.Stats() }))
+type WatchStats struct {
+	// Polls is the number of notification polls, failed ones included
+	Polls int64
+	// ChangedPolls is the number of polls reporting modified namespaces
+	ChangedPolls int64
+	// Errors is the number of failed polls
+	Errors int64
+	// BytesFetched is the size of the response bodies read from apollo,
+	// configurations and notifications
+	BytesFetched int64
+	// LastPoll is when the last poll returned, zero before the first one
+	LastPoll time.Time
+	// LastChange is when the last poll reporting modifications returned
+	LastChange time.Time
+	// Backoff is the delay before the next poll after failures, zero while
+	// polls succeed
+	Backoff time.Duration
+}
+
+// Stats returns the cumulative activity of the watcher
+func (a *Apollo) Stats() WatchStats {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	return a.stats
+}
+
+// recordPoll Count a notification poll and the backoff it resulted in
+func (a *Apollo) recordPoll(modified bool, err error, backoff time.Duration) {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	now := time.Now()
+	a.stats.Polls++
+	a.stats.LastPoll = now
+	a.stats.Backoff = backoff
+	if err != nil {
+		a.stats.Errors++
+		return
+	}
+	a.lastSuccess = now
+	if modified {
+		a.stats.ChangedPolls++
+		a.stats.LastChange = now
+	}
+}
+
+// recordBytes Count bytes read from apollo
+func (a *Apollo) recordBytes(n int) {
+	a.statsMu.Lock()
+	a.stats.BytesFetched += int64(n)
+	a.statsMu.Unlock()
+}
+
+// PublishExpvar publishes the activity of the watcher with expvar, as a map
+// named prefix holding polls_total, changes_total, errors_total,
+// bytes_fetched_total and last_fetch_unixtime, the time of the last successful
+// poll. Values are read from Stats when the map is served. A prefix already
+// published is left as is.
+func PublishExpvar(prefix string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.expvarPrefix = prefix
+	})
+}
+
+// publishExpvar Publish the map of PublishExpvar
+func (a *Apollo) publishExpvar() {
+	if expvar.Get(a.expvarPrefix) != nil {
+		a.logger.Printf("Apollo expvar %s already published", a.expvarPrefix)
+		return
+	}
+	m := new(expvar.Map)
+	stat := func(value func(s WatchStats) int64) expvar.Func {
+		return func() interface{} { return value(a.Stats()) }
+	}
+	m.Set("polls_total", stat(func(s WatchStats) int64 { return s.Polls }))
+	m.Set("changes_total", stat(func(s WatchStats) int64 { return s.ChangedPolls }))
+	m.Set("errors_total", stat(func(s WatchStats) int64 { return s.Errors }))
+	m.Set("bytes_fetched_total", stat(func(s WatchStats) int64 { return s.BytesFetched }))
+	m.Set("last_fetch_unixtime", expvar.Func(func() interface{} {
+		a.statsMu.Lock()
+		defer a.statsMu.Unlock()
+		if a.lastSuccess.IsZero() {
+			return int64(0)
+		}
+		return a.lastSuccess.Unix()
+	}))
+	expvar.Publish(a.expvarPrefix, m)
+}
@@ -0,0 +1,83 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	var polls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"},"releaseKey":"r1"}`))
+	})
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&polls, 1) {
+		case 1:
+			_, _ = w.Write([]byte(`[{"namespaceName":"application","notificationId":1}]`))
+		case 2:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WatchBackoff(time.Minute, time.Minute), WithLogger(discard))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for a.Stats().Errors == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopWatch(a)
+
+	stats := a.Stats()
+	if stats.Polls != 3 || stats.ChangedPolls != 1 || stats.Errors != 1 {
+		t.Fatalf("unexpected counters %+v", stats)
+	}
+	if stats.BytesFetched == 0 || stats.LastPoll.IsZero() || stats.LastChange.IsZero() {
+		t.Fatalf("expected bytes and times to be recorded, got %+v", stats)
+	}
+	if stats.Backoff != time.Minute {
+		t.Fatalf("expected backoff of 1m, got %v", stats.Backoff)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), PublishExpvar("vapollo_test"), WithLogger(discard))
+	a.recordPoll(true, nil, 0)
+	a.recordBytes(42)
+
+	m, ok := expvar.Get("vapollo_test").(*expvar.Map)
+	if !ok {
+		t.Fatalf("expected an expvar map, got %T", expvar.Get("vapollo_test"))
+	}
+	if got := m.Get("changes_total").String(); got != "1" {
+		t.Fatalf("expected 1 change, got %s", got)
+	}
+	if got := m.Get("bytes_fetched_total").String(); got != "42" {
+		t.Fatalf("expected 42 bytes, got %s", got)
+	}
+	if got := m.Get("last_fetch_unixtime").String(); got == "0" {
+		t.Fatalf("expected the last fetch time, got %s", got)
+	}
+
+	// publishing the same prefix again must not panic
+	InitApollo(Server(ts.URL), AppId("app"), PublishExpvar("vapollo_test"), WithLogger(discard))
+}
@@ -0,0 +1,72 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import "sync"
+
+// ConfigSource tells where the configuration in use was read from
+type ConfigSource string
+
+const (
+	// SourceLocal means nothing was loaded from apollo yet, only the local
+	// file is in use
+	SourceLocal ConfigSource = "local"
+	// SourceRemote means the configuration was fetched from apollo
+	SourceRemote ConfigSource = "remote"
+	// SourceCache means apollo couldn't be reached and at least one
+	// namespace was read from the disk cache
+	SourceCache ConfigSource = "cache"
+	// SourceStatic means the configuration was set by InitStatic
+	SourceStatic ConfigSource = "static"
+)
+
+// Status describes the configuration set up by Init or InitFromEnv
+type Status struct {
+	// Source is where the configuration last loaded was read from
+	Source ConfigSource
+	// Env is the environment resolved by Init, empty for InitFromEnv
+	Env string
+}
+
+// Source returns where the configuration last loaded was read from
+func (a *Apollo) Source() ConfigSource {
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	if a.source == "" {
+		return SourceLocal
+	}
+	return a.source
+}
+
+var (
+	initMu     sync.Mutex
+	initApollo *Apollo
+	initEnv    string
+)
+
+// InitStatus returns the status of the configuration set up by Init or
+// InitFromEnv, e.g. to log whether this instance actually reached apollo:
+//
+//	status := vapollo.InitStatus()
+//	log.Printf("config source=%s env=%s", status.Source, status.Env)
+//
+// Source is SourceLocal before Init.
+func InitStatus() Status {
+	initMu.Lock()
+	apollo, env := initApollo, initEnv
+	initMu.Unlock()
+	if apollo == nil {
+		return Status{Source: SourceLocal, Env: env}
+	}
+	return Status{Source: apollo.Source(), Env: env}
+}
+
+// setInitStatus Record the apollo instance and env set up by Init
+func setInitStatus(apollo *Apollo, env string) {
+	initMu.Lock()
+	initApollo, initEnv = apollo, env
+	initMu.Unlock()
+}
@@ -0,0 +1,47 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import "testing"
+
+func TestSource(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	a := InitApollo(Server(ts.URL), AppId("app"), CacheDir(t.TempDir()), WithLogger(discard))
+	if got := a.Source(); got != SourceLocal {
+		t.Fatalf("expected local source before any load, got %s", got)
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.Source(); got != SourceRemote {
+		t.Fatalf("expected remote source, got %s", got)
+	}
+	ts.Close()
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load from cache: %v", err)
+	}
+	if got := a.Source(); got != SourceCache {
+		t.Fatalf("expected cache source, got %s", got)
+	}
+}
+
+func TestInitStatus(t *testing.T) {
+	defer setInitStatus(nil, "")
+	if got := InitStatus(); got.Source != SourceLocal {
+		t.Fatalf("expected local source before init, got %s", got.Source)
+	}
+
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+	a := InitApollo(Server(ts.URL), AppId("app"))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	setInitStatus(a, "qa")
+	if got := InitStatus(); got != (Status{Source: SourceRemote, Env: "qa"}) {
+		t.Fatalf("unexpected status %+v", got)
+	}
+}
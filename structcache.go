@@ -0,0 +1,93 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// StructCacheFile keeps the struct interface in the file at path, as json,
+// each time settings were parsed to it successfully. If configuration can't
+// be read from apollo when InitViperRemote starts, the struct is restored
+// from the file instead of failing, and the instance runs degraded until a
+// configuration is read from apollo, see Degraded. It keeps services binding
+// a struct bootable during long apollo outages. Configuration rejected once
+// read, e.g. by SchemaValidator or OnFirstLoad, still fails InitViperRemote.
+//
+// The file holds every exported field of the struct in plaintext, including
+// secrets resolved by SecretResolver, and is only readable by its owner (mode
+// 0600). Use json:"-" tags to leave fields out of it.
+func StructCacheFile(path string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.structCacheFile = path
+	})
+}
+
+// Degraded reports whether the struct interface was restored from the file of
+// StructCacheFile because apollo couldn't be reached, and no configuration
+// has been read from apollo since
+func (a *Apollo) Degraded() bool {
+	a.degradedMu.Lock()
+	defer a.degradedMu.Unlock()
+	return a.degraded
+}
+
+func (a *Apollo) setDegraded(degraded bool) {
+	a.degradedMu.Lock()
+	a.degraded = degraded
+	a.degradedMu.Unlock()
+}
+
+// setLoadError Record the error of the last load read by viper, nil if it
+// succeeded
+func (a *Apollo) setLoadError(err error) {
+	a.degradedMu.Lock()
+	a.loadErr = err
+	a.degradedMu.Unlock()
+}
+
+func (a *Apollo) loadError() error {
+	a.degradedMu.Lock()
+	defer a.degradedMu.Unlock()
+	return a.loadErr
+}
+
+// saveStruct Write the struct interface to the struct cache file, unless it
+// was restored from it and not read from apollo since
+func (a *Apollo) saveStruct() {
+	if a.structCacheFile == "" || a.Degraded() {
+		return
+	}
+	b, err := json.Marshal(a.object)
+	if err == nil {
+		// the file may hold secrets, writeFileAtomic creates it 0600
+		err = writeFileAtomic(a.structCacheFile, b)
+	}
+	if err != nil {
+		a.logger.Printf("Failed writing struct cache: %v", err)
+	}
+}
+
+// restoreStruct Restore the struct interface from the struct cache file after
+// loadErr prevented reading configuration from apollo, report whether it was
+// restored
+func (a *Apollo) restoreStruct(loadErr error) bool {
+	if a.structCacheFile == "" || a.object == nil {
+		return false
+	}
+	b, err := os.ReadFile(a.structCacheFile)
+	if err == nil {
+		err = json.Unmarshal(b, a.object)
+	}
+	if err != nil {
+		a.logger.Printf("Failed restoring struct cache: %v", err)
+		return false
+	}
+	a.setDegraded(true)
+	a.logger.Printf("Failed reading apollo config, running degraded on the cached struct: %v", loadErr)
+	return true
+}
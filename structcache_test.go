@@ -0,0 +1,74 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestStructCacheFile(t *testing.T) {
+	type config struct {
+		Port int `mapstructure:"port"`
+	}
+	file := filepath.Join(t.TempDir(), "struct.json")
+
+	ts := newFakeApollo(`{"port":"8080"}`)
+	live := config{}
+	a := InitApollo(Server(ts.URL), AppId("app"), Struct(&live), StructCacheFile(file), IgnoreLocalSettings(), WithLogger(discard))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	stopWatch(a)
+	if a.Degraded() {
+		t.Fatalf("expected not degraded with apollo reachable")
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the struct cache readable by its owner only, got %v, %v", info, err)
+	}
+	ts.Close()
+
+	restored := config{}
+	a = InitApollo(Server(ts.URL), AppId("app"), Struct(&restored), StructCacheFile(file), IgnoreLocalSettings(), WithLogger(discard))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("expected InitViperRemote to restore the struct, got %v", err)
+	}
+	stopWatch(a)
+	if restored.Port != 8080 || !a.Degraded() {
+		t.Fatalf("expected the struct restored in degraded mode, got %+v, degraded=%v", restored, a.Degraded())
+	}
+}
+
+func TestStructCacheFileKeepsRejections(t *testing.T) {
+	type config struct {
+		Port int `mapstructure:"port"`
+	}
+	file := filepath.Join(t.TempDir(), "struct.json")
+	if err := os.WriteFile(file, []byte(`{"Port":8080}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := newFakeApollo(`{"port":"80"}`)
+	defer ts.Close()
+
+	rejected := errors.New("rejected")
+	for name, opt := range map[string]Option{
+		"OnFirstLoad":     OnFirstLoad(func(map[string]interface{}) error { return rejected }),
+		"SchemaValidator": SchemaValidator(func(map[string]interface{}) error { return rejected }),
+	} {
+		cfg := config{}
+		a := InitApollo(Server(ts.URL), AppId("app"), Struct(&cfg), StructCacheFile(file), opt, IgnoreLocalSettings(), WithLogger(discard))
+		if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); !errors.Is(err, rejected) {
+			t.Fatalf("%s: expected the rejection returned, got %v", name, err)
+		}
+		if cfg.Port != 0 || a.Degraded() {
+			t.Fatalf("%s: expected the struct not restored, got %+v, degraded=%v", name, cfg, a.Degraded())
+		}
+	}
+}
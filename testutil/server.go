@@ -0,0 +1,218 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package testutil provides a fake apollo server, to test code embedding
+// vapollo without a real apollo:
+//
+//	s := testutil.NewServer()
+//	defer s.Close()
+//	s.Publish("application", map[string]string{"timeout": "3s"})
+//	apollo := vapollo.InitApollo(vapollo.Server(s.URL), vapollo.AppId("app"))
+//
+// Publishing again notifies watching clients, as apollo does.
+package testutil
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLongPollTimeout is how long notification polls are held when no
+// namespace changed, much shorter than apollo's minute to keep tests fast
+const DefaultLongPollTimeout = time.Second
+
+// Server is a fake apollo server serving /configs, /configfiles,
+// /configfiles/json and /notifications/v2 for any appId and cluster.
+// Namespaces in other formats than properties, e.g. db.yaml, are published
+// as apollo stores them, their text under the "content" key.
+type Server struct {
+	*httptest.Server
+
+	// LongPollTimeout is how long notification polls are held before
+	// answering 304 when no namespace changed
+	LongPollTimeout time.Duration
+
+	mu         sync.Mutex
+	namespaces map[string]*namespace
+	// changed is closed and replaced on every publish to wake long polls
+	changed chan struct{}
+}
+
+// namespace is a namespace as published to the fake server
+type namespace struct {
+	configurations map[string]string
+	notificationID int64
+}
+
+type notification struct {
+	NamespaceName  string `json:"namespaceName"`
+	NotificationID int64  `json:"notificationId"`
+}
+
+// NewServer starts a fake apollo server without any namespace, the caller
+// should Close it when done
+func NewServer() *Server {
+	s := &Server{
+		LongPollTimeout: DefaultLongPollTimeout,
+		namespaces:      map[string]*namespace{},
+		changed:         make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", s.serveConfigs)
+	mux.HandleFunc("/configfiles/", s.serveRawFiles)
+	mux.HandleFunc("/configfiles/json/", s.serveConfigFiles)
+	mux.HandleFunc("/notifications/v2", s.serveNotifications)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Publish releases configurations as the content of ns, and notifies the
+// clients watching it
+func (s *Server) Publish(ns string, configurations map[string]string) {
+	copied := make(map[string]string, len(configurations))
+	for k, v := range configurations {
+		copied[k] = v
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.namespaces[ns]
+	if !ok {
+		n = &namespace{}
+		s.namespaces[ns] = n
+	}
+	n.configurations = copied
+	n.notificationID++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// ReleaseKey returns the release key of the configurations last published to
+// ns, or "" if nothing was published to it
+func (s *Server) ReleaseKey(ns string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, ok := s.namespaces[ns]; ok {
+		return releaseKey(ns, n.notificationID)
+	}
+	return ""
+}
+
+func releaseKey(ns string, id int64) string {
+	return ns + "-" + strconv.FormatInt(id, 10)
+}
+
+// lookup Return the configurations and release key of the namespace
+// addressed by the last segment of path
+func (s *Server) lookup(path string) (string, map[string]string, string, bool) {
+	ns := path[strings.LastIndex(path, "/")+1:]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.namespaces[ns]
+	if !ok {
+		return ns, nil, "", false
+	}
+	return ns, n.configurations, releaseKey(ns, n.notificationID), true
+}
+
+func (s *Server) serveConfigs(w http.ResponseWriter, r *http.Request) {
+	ns, configurations, key, ok := s.lookup(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"namespaceName":  ns,
+		"configurations": configurations,
+		"releaseKey":     key,
+	})
+}
+
+func (s *Server) serveConfigFiles(w http.ResponseWriter, r *http.Request) {
+	_, configurations, _, ok := s.lookup(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, configurations)
+}
+
+// serveRawFiles Answer the content of namespaces in other formats than
+// properties as is, and properties namespaces as key=value lines
+func (s *Server) serveRawFiles(w http.ResponseWriter, r *http.Request) {
+	ns, configurations, _, ok := s.lookup(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain;charset=UTF-8")
+	if ext := path.Ext(ns); ext != "" && ext != ".properties" {
+		_, _ = io.WriteString(w, configurations["content"])
+		return
+	}
+	keys := make([]string, 0, len(configurations))
+	for k := range configurations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, _ = io.WriteString(w, k+"="+configurations[k]+"\n")
+	}
+}
+
+// serveNotifications Answer the namespaces changed since the notification ids
+// of the client, holding the poll until one changes or LongPollTimeout
+func (s *Server) serveNotifications(w http.ResponseWriter, r *http.Request) {
+	var polled []notification
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("notifications")), &polled); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeout := time.NewTimer(s.LongPollTimeout)
+	defer timeout.Stop()
+	for {
+		changed, wait := s.changes(polled)
+		if len(changed) > 0 {
+			writeJSON(w, changed)
+			return
+		}
+		select {
+		case <-wait:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// changes Return the polled namespaces with newer notification ids, and a
+// channel closed on the next publish
+func (s *Server) changes(polled []notification) ([]notification, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []notification
+	for _, p := range polled {
+		if n, ok := s.namespaces[p.NamespaceName]; ok && n.notificationID > p.NotificationID {
+			changed = append(changed, notification{p.NamespaceName, n.notificationID})
+		}
+	}
+	return changed, s.changed
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,79 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package testutil_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kyeason/vapollo"
+	"github.com/kyeason/vapollo/testutil"
+	"github.com/spf13/viper"
+)
+
+func TestServerNotFound(t *testing.T) {
+	s := testutil.NewServer()
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/configs/app/default/missing")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown namespace, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerNotifiesPublish(t *testing.T) {
+	s := testutil.NewServer()
+	defer s.Close()
+	s.Publish("application", map[string]string{"a": "1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	apollo := vapollo.InitApollo(vapollo.Server(s.URL), vapollo.AppId("app"), vapollo.BaseContext(ctx),
+		vapollo.WithLogger(log.New(io.Discard, "", 0)))
+	if _, err := vapollo.InitViperRemote(apollo, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	defer apollo.StopWatch()
+
+	wait, cancelWait := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelWait()
+	if err := apollo.WaitForRelease(wait, s.ReleaseKey("application")); err != nil {
+		t.Fatalf("waiting for first release: %v", err)
+	}
+	s.Publish("application", map[string]string{"a": "2"})
+	if err := apollo.WaitForRelease(wait, s.ReleaseKey("application")); err != nil {
+		t.Fatalf("waiting for second release: %v", err)
+	}
+}
+
+func TestServerRawFile(t *testing.T) {
+	s := testutil.NewServer()
+	defer s.Close()
+	s.Publish("application", map[string]string{"b": "2", "a": "1"})
+	s.Publish("db.yaml", map[string]string{"content": "host: db\n"})
+
+	apollo := vapollo.InitApollo(vapollo.Server(s.URL), vapollo.AppId("app"), vapollo.WithLogger(log.New(io.Discard, "", 0)))
+	for ns, want := range map[string]string{"application": "a=1\nb=2\n", "db.yaml": "host: db\n"} {
+		b, err := apollo.RawFile(ns)
+		if err != nil {
+			t.Fatalf("RawFile(%s): %v", ns, err)
+		}
+		if string(b) != want {
+			t.Fatalf("RawFile(%s): expected %q, got %q", ns, want, b)
+		}
+	}
+	if _, err := apollo.RawFile("missing"); err == nil {
+		t.Fatal("expected an error for an unknown namespace")
+	}
+}
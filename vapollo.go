@@ -11,39 +11,257 @@ package vapollo
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Apollo parameters definition
 type Apollo struct {
+	// serverMu guards cluster and servers, which Reconfigure swaps, and
+	// server, the active server of the pool of servers
 	cluster       string
+	serverMu      sync.RWMutex
 	server        string
+	servers       []string
+	srvService    string
 	namespaceName string
-	appID         string
-	releaseKey    string
-	ip            string
-	notifications []notification
+	namespaces    []string
+	maxNamespaces int
+	loadWorkers   int
+	mergeStrategy MergeStrategy
+	bestEffort    bool
+	// prefixByNamespace nests configurations under their namespace name
+	prefixByNamespace bool
+	// sourceList is set by Sources, sources maps the namespaces resolved
+	// from it to their app, cluster and name
+	sourceList []Source
+	sources    map[string]Source
+	// namespaceFormats is set by NamespaceFormat
+	namespaceFormats map[string]string
+	// reloadSlots bounds the reloads in flight if MaxConcurrentReloads is
+	// set, reloadsInFlight is updated atomically
+	reloadSlots     chan struct{}
+	reloadsInFlight int32
+	observer        Observer
+	appID           string
+	ip              string
+	labels          map[string]string
+	notifications   []notification
 
 	// If a struct interface was provided, vapollo will unmarshal the
 	// key/values to the object
-	object interface{}
-	notify chan bool
+	object      interface{}
+	subtrees    []subtreeBinding
+	ignoreLocal bool
+	localFirst  bool
+	// localKeys are the top level keys of local settings layered into the
+	// remote viper, guarded by configMu
+	localKeys   map[string]bool
+	notify      chan bool
+	onChange    func()
+	afterReload func(v *viper.Viper)
+	onFirstLoad func(cfg map[string]interface{}) error
+	onError     func(err error)
+	logger      Logger
+
+	// onRetriesExhausted is called once the backoff threshold is reached
+	onRetriesExhausted func(namespace string, lastErr error)
+
+	// onBatchChange is called with changedNamespaces, the namespaces
+	// notified as modified and not delivered yet, guarded by changedMu
+	onBatchChange     func(changed []string)
+	changedMu         sync.Mutex
+	changedNamespaces []string
+
+	// callbackMode tells how callbacks are run, callbackSlots bounds the
+	// callbacks running concurrently in CallbackAsync mode
+	callbackMode  CallbackMode
+	callbackSlots chan struct{}
+
+	// structCacheFile keeps the struct interface, degraded reports it was
+	// restored from the file and loadErr is the error of the last load read
+	// by viper, guarded by degradedMu
+	structCacheFile string
+	degradedMu      sync.Mutex
+	degraded        bool
+	loadErr         error
+
+	accessKey            *accessKey
+	cacheDir             string
+	compressCache        bool
+	persistNotifications bool
+
+	client            *http.Client
+	transportWrappers []func(http.RoundTripper) http.RoundTripper
+	transportOptions  []func(*http.Transport)
+	// clonedTransport is the transport cloned to apply transportOptions,
+	// used by this instance only
+	clonedTransport   *http.Transport
+	proxy             string
+	proxyUser         *url.Userinfo
+	requestMutators   []func(*http.Request) error
+	strictResponse    bool
+	strictDecoding    bool
+	onDecodeError     func(phase string, err error)
+	decodeTimeout     time.Duration
+	redactPattern     *regexp.Regexp
+	effectiveFormat   string
+	configurationsKey string
+	maxResponseBytes  int64
+	limiter           *rate.Limiter
+	startupJitter     time.Duration
+	jitterOnce        sync.Once
+
+	// portal and portalEnv locate the release history of ReleaseHistory and
+	// the key metadata of KeyMeta, kept in keyMeta by namespace
+	portal      string
+	portalEnv   string
+	keyMetadata bool
+	keyMetaMu   sync.RWMutex
+	keyMeta     map[string]map[string]KeyMeta
+
+	codec               JSONCodec
+	keyFilter           func(key string) bool
+	keyTransformer      func(key string) string
+	schemaValidator     func(cfg map[string]interface{}) error
+	environPrefix       string
+	environTransform    func(key string) string
+	secretResolver      func(ref string) (string, error)
+	secretPrefix        string
+	interpolate         bool
+	strictInterpolation bool
+	interpolationDepth  int
+	inferTypes          bool
+	templateValues      bool
+	trimValues          bool
+	stripComments       bool
+	reconstructArrays   bool
+
+	// lazy defers the first load to the first access, done by lazyOnce
+	// which keeps its error in lazyErr and sets Remote to lazyRemote
+	lazy       bool
+	lazyOnce   sync.Once
+	lazyErr    error
+	lazyRemote *viper.Viper
+
+	// baseCtx bounds the lifetime of the watcher and of requests to apollo
+	baseCtx context.Context
+
+	// watchMu guards the watcher lifecycle: responses is shared by every
+	// watcher started, quit is non-nil while a watcher is running and stopped
+	// is closed when it returns
+	watchMu   sync.Mutex
+	responses chan *viper.RemoteResponse
+	quit      chan bool
+	stopped   chan struct{}
+	// backoff and resumed are only used by the running watcher, resumed
+	// records notification ids restored by PersistNotifications
+	backoff backoff
+	resumed bool
+
+	// static is the configuration set by InitStatic, served instead of
+	// apollo's
+	static []byte
+
+	// statsMu guards stats, the cumulative activity of the watcher, and
+	// lastSuccess, when the last notification poll succeeded
+	statsMu     sync.Mutex
+	stats       WatchStats
+	lastSuccess time.Time
+	// expvarPrefix names the expvar map of PublishExpvar
+	expvarPrefix string
+	// strictStartup is set by StrictStartup, startupErrs then collects the
+	// errors of the initial load, guarded by startupMu
+	strictStartup bool
+	startupMu     sync.Mutex
+	startupErrs   []error
+	// staleAfter is the window without successful poll after which the
+	// configuration is reported stale
+	staleAfter time.Duration
+
+	// minPollInterval and maxPollInterval bound the interval between reads
+	// of configuration by the watcher, which waits watchStartDelay before
+	// its first poll
+	minPollInterval time.Duration
+	maxPollInterval time.Duration
+	watchStartDelay time.Duration
+	// notModifiedStatus and notModifiedOnEmptyBody detect notification
+	// responses meaning no namespace was modified
+	notModifiedStatus      []int
+	notModifiedOnEmptyBody bool
+	// conditionalNotifications sends a validator of the notification ids
+	// with notification polls
+	conditionalNotifications bool
+
+	// standbyMu guards standby, warm, the configuration fetched while in
+	// standby, and promoted, the configuration to apply on Promote
+	standbyMu sync.Mutex
+	standby   bool
+	warm      []byte
+	promoted  []byte
+
+	// pauseMu guards paused and pending, pending records a modification
+	// received while paused
+	pauseMu sync.Mutex
+	paused  bool
+	pending bool
+	// applyMu serializes applying configuration, and guards lastApply, the
+	// time configuration was last read by the watcher
+	applyMu   sync.Mutex
+	lastApply time.Time
+
+	// releaseKeysMu guards releaseKeys, the release key of each namespace,
+	// and activeCluster, the cluster which served the first namespace
+	releaseKeysMu sync.RWMutex
+	releaseKeys   map[string]string
+	activeCluster string
+
+	// configMu guards config, the configuration last loaded from apollo, its
+	// source, namespaceConfigs and namespaceContent, the configuration of each
+	// namespace after and before transformations, namespaceVipers,
+	// servedKeys, the keys last served to viper, and deletedKeys, those
+	// deleted by the last read
+	configMu         sync.RWMutex
+	config           map[string]interface{}
+	source           ConfigSource
+	namespaceConfigs map[string]map[string]interface{}
+	namespaceContent map[string][]byte
+	namespaceVipers  map[string]*viper.Viper
+	viperOptions     []viper.Option
+	servedKeys       map[string]bool
+	deletedKeys      []string
+
+	deletedKeyPolicy DeletedKeyPolicy
+	// structDefaults holds the values of the struct interface before any
+	// configuration was decoded
+	structDefaults map[string]interface{}
+
+	// lastHeaderMu guards lastHeader only, which holds the headers of the
+	// last configuration response
+	lastHeaderMu sync.RWMutex
+	lastHeader   http.Header
+	// validatedMu guards validated, the last configuration responses
+	// carrying an ETag or Last-Modified header, by path
+	validatedMu sync.Mutex
+	validated   map[string]*validatedResponse
 }
 
 // apollo notification structure
@@ -61,6 +279,12 @@ type apolloResponse struct {
 	AppID          string          `json:"appId"`
 }
 
+// Logger is used by vapollo to report what happens while watching apollo.
+// *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
 type Option interface {
 	apply(a *Apollo)
 }
@@ -101,12 +325,125 @@ func Struct(obj interface{}) Option {
 	})
 }
 
+// IgnoreLocalSettings stops InitViperRemote from parsing local settings, e.g.
+// the bootstrap file holding apollo coordinates, to the struct interface
+func IgnoreLocalSettings() Option {
+	return optionFunc(func(a *Apollo) {
+		a.ignoreLocal = true
+	})
+}
+
 func Notify(notify chan bool) Option {
 	return optionFunc(func(a *Apollo) {
 		a.notify = notify
 	})
 }
 
+// IP sets the client ip reported to apollo, which targets gray releases by ip
+func IP(ip string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.ip = ip
+	})
+}
+
+// Labels sets query parameters sent to apollo along with ip, e.g.
+// Labels(map[string]string{"label": "canary"}) targets gray releases by label
+func Labels(labels map[string]string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.labels = labels
+	})
+}
+
+// StrictResponse rejects apollo configuration responses holding fields
+// unknown to vapollo, to catch incompatible apollo versions early
+func StrictResponse() Option {
+	return optionFunc(func(a *Apollo) {
+		a.strictResponse = true
+	})
+}
+
+// ConfigurationsKey sets the field of apollo responses holding the
+// configurations, "configurations" by default. Some apollo compatible servers
+// use "data" or "content" instead.
+func ConfigurationsKey(key string) Option {
+	return optionFunc(func(a *Apollo) {
+		a.configurationsKey = key
+	})
+}
+
+// OnChange registers a callback invoked every time modified configuration has
+// been read from apollo, run as WithCallbackMode tells
+func OnChange(fn func()) Option {
+	return optionFunc(func(a *Apollo) {
+		a.onChange = fn
+	})
+}
+
+// OnBatchChange registers a callback invoked once with all the namespaces
+// modified since the previous delivery, after OnChange, so that objects
+// depending on several namespaces are rebuilt once when they changed
+// together. Namespaces modified while paused are reported together on Resume.
+func OnBatchChange(fn func(changed []string)) Option {
+	return optionFunc(func(a *Apollo) {
+		a.onBatchChange = fn
+	})
+}
+
+// AfterReload registers fn, run on the remote viper every time configuration
+// has been read from apollo, initially and on each modification, before it is
+// mapped to the struct interface and OnChange is called. It is the place to
+// set derived keys or bind env variables again, changes made by fn are seen
+// by the struct interface.
+func AfterReload(fn func(v *viper.Viper)) Option {
+	return optionFunc(func(a *Apollo) {
+		a.afterReload = fn
+	})
+}
+
+// BaseContext bounds the lifetime of the watcher to ctx: once ctx is done the
+// watch loop returns, in-flight requests including the notification long-poll
+// are aborted and retry timers are stopped.
+func BaseContext(ctx context.Context) Option {
+	return optionFunc(func(a *Apollo) {
+		a.baseCtx = ctx
+	})
+}
+
+// OnError registers a callback invoked with errors which prevented loaded
+// configuration from being applied, e.g. a failed SchemaValidator
+func OnError(fn func(err error)) Option {
+	return optionFunc(func(a *Apollo) {
+		a.onError = fn
+	})
+}
+
+// SchemaValidator registers validate, run on every load and reload on the
+// merged configurations before they are applied. If validate fails the
+// previous configuration is kept, and the error is logged and passed to the
+// OnError callback, so that a bad release can't take down running instances.
+func SchemaValidator(validate func(cfg map[string]interface{}) error) Option {
+	return optionFunc(func(a *Apollo) {
+		a.schemaValidator = validate
+	})
+}
+
+// OnFirstLoad registers a hook run once on the configurations initially read
+// from apollo, before watching them. Keys are those published in apollo, e.g.
+// cfg["config.schema"]. An error returned by fn aborts InitViperRemote, and
+// so startup when using Init.
+func OnFirstLoad(fn func(cfg map[string]interface{}) error) Option {
+	return optionFunc(func(a *Apollo) {
+		a.onFirstLoad = fn
+	})
+}
+
+// WithLogger replaces the standard logger used while watching apollo
+func WithLogger(l Logger) Option {
+	return optionFunc(func(a *Apollo) {
+		a.logger = l
+	})
+}
+
 // Init initialize configuration from local file, assuming that there is a variable "env" that determines the
 // configuration of a specific runtime environment. e.g.
 //
@@ -129,73 +466,259 @@ func Notify(notify chan bool) Option {
 //	Usage:
 //		Init("app.json", "json", "apollo", nil)
 //		Init("app.yml", "yaml", "", &config)
-func Init(fileName, fileType, apolloKey string, dStruct interface{}) (v *viper.Viper, err error) {
+//		Init("app.yml", "yaml", "", &config, vapollo.EnvPrefix("apollo"))
+//		Init("app.yml", "yaml", "", &config, vapollo.RequireEnv(), vapollo.AllowedEnvs("dev", "qa"))
+//
+// Failing to read the local file is returned as an error wrapping
+// ErrLocalFileNotFound or ErrLocalFileParse, or the error of the file system,
+// e.g. os.ErrPermission.
+func Init(fileName, fileType, apolloKey string, dStruct interface{}, opts ...InitOption) (v *viper.Viper, err error) {
+	o := initOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	pflag.String("env", "prod", "Running environment(dev/qa/pre/prod)")
 	pflag.Parse()
 	viper.BindPFlags(pflag.CommandLine)
 	env := viper.GetString("env")
-	viper.AddConfigPath(filepath.Dir(os.Args[0]))
+	if err := o.checkEnv(env, viper.IsSet("env")); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(os.Args[0])
+	viper.AddConfigPath(dir)
 	viper.SetConfigName(filepath.Base(fileName))
 	viper.SetConfigType(fileType)
 	err = viper.ReadInConfig()
 	if err != nil {
-		log.Panicln("Failed reading local config: ", err)
+		path := viper.ConfigFileUsed()
+		if path == "" {
+			path = filepath.Join(dir, filepath.Base(fileName))
+		}
+		return nil, localFileError(path, err)
 	}
 	key := apolloKey
 	if len(apolloKey) > 0 {
 		key = apolloKey + "."
 	}
-	v = viper.Sub(env)
-	notify := make(chan bool)
-	opts := []Option{
+	v, err = subEnv(viper.GetViper(), o.envPrefix, env)
+	if err != nil {
+		return nil, err
+	}
+	apolloOpts := []Option{
 		Server(v.GetString(key + "ip")),
 		AppId(v.GetString(key + "appId")),
 		NamespaceName(v.GetString(key + "namespaceName")),
 		Struct(dStruct),
-		Notify(notify),
 	}
-	apollo := InitApollo(opts...)
-	_, err = InitViperRemote(apollo, viper.KeyDelimiter(":"))
+	if o.strict {
+		apolloOpts = append(apolloOpts, StrictStartup())
+	}
+	apollo, err := initRemote(apolloOpts...)
 	_ = v.BindPFlags(pflag.CommandLine)
 	if err != nil {
+		if o.strict {
+			return nil, err
+		}
 		log.Panicln("Failed init apollo config: ", err)
 	}
-	// Waiting for remote configuration
-	go func(quit chan bool) {
-		time.Sleep(time.Millisecond * 800)
-		quit <- true
-	}(notify)
-	select {
-	case <-notify:
-		return v, nil
+	setInitStatus(apollo, env)
+	return v, nil
+}
+
+// InitOption customizes how Init reads the local file
+type InitOption func(o *initOptions)
+
+type initOptions struct {
+	envPrefix   string
+	requireEnv  bool
+	allowedEnvs []string
+	strict      bool
+}
+
+// EnvPrefix sets the key under which the env subtrees live in the local file,
+// e.g. with EnvPrefix("apollo") the configuration of env "dev" is read from
+// "apollo.dev" instead of "dev"
+func EnvPrefix(prefix string) InitOption {
+	return func(o *initOptions) {
+		o.envPrefix = prefix
+	}
+}
+
+// RequireEnv makes Init fail unless env is set explicitly, e.g. by the --env
+// flag, instead of silently defaulting to prod
+func RequireEnv() InitOption {
+	return func(o *initOptions) {
+		o.requireEnv = true
+	}
+}
+
+// AllowedEnvs makes Init fail if env is not one of envs, e.g. to refuse prod
+// in test images
+func AllowedEnvs(envs ...string) InitOption {
+	return func(o *initOptions) {
+		o.allowedEnvs = envs
 	}
-	return v, errors.New("failed retrieving remote config:[EMPTY]")
+}
+
+// StrictInit makes Init set up apollo with StrictStartup, and return the
+// errors of initializing apollo instead of panicking
+func StrictInit() InitOption {
+	return func(o *initOptions) {
+		o.strict = true
+	}
+}
+
+// checkEnv Check env against RequireEnv and AllowedEnvs, explicit reports
+// whether env was set rather than defaulted
+func (o initOptions) checkEnv(env string, explicit bool) error {
+	if o.requireEnv && !explicit {
+		return errors.New("env is required but not set, pass --env explicitly")
+	}
+	if len(o.allowedEnvs) > 0 && !stringInSlice(env, o.allowedEnvs) {
+		return fmt.Errorf("env %q is not allowed, expected one of %v", env, o.allowedEnvs)
+	}
+	return nil
+}
+
+// subEnv Return the subtree of v holding the configuration of env
+func subEnv(v *viper.Viper, prefix, env string) (*viper.Viper, error) {
+	key := env
+	if prefix != "" {
+		key = prefix + "." + env
+	}
+	sub := v.Sub(key)
+	if sub == nil {
+		err := fmt.Errorf("env %q not found in local config", env)
+		if prefix != "" {
+			err = fmt.Errorf("env %q not found in local config under %q", env, prefix)
+		}
+		return nil, err
+	}
+	return sub, nil
+}
+
+// InitFromEnv initialize configuration from apollo without any local file,
+// apollo coordinates are read from environment variables:
+//
+//	APOLLO_SERVER:    Apollo server, mandatory
+//	APOLLO_APP_ID:    Apollo appId, mandatory
+//	APOLLO_CLUSTER:   Apollo cluster, "default" if empty
+//	APOLLO_NAMESPACE: Apollo namespace, "application" if empty
+//
+// The remote viper is returned, and dStruct is filled if not nil.
+func InitFromEnv(dStruct interface{}) (*viper.Viper, error) {
+	server, appID := os.Getenv("APOLLO_SERVER"), os.Getenv("APOLLO_APP_ID")
+	if server == "" || appID == "" {
+		return nil, errors.New("failed init apollo from env: APOLLO_SERVER and APOLLO_APP_ID are required")
+	}
+	opts := []Option{
+		Server(server),
+		AppId(appID),
+		Struct(dStruct),
+	}
+	if cluster := os.Getenv("APOLLO_CLUSTER"); cluster != "" {
+		opts = append(opts, Cluster(cluster))
+	}
+	if namespace := os.Getenv("APOLLO_NAMESPACE"); namespace != "" {
+		opts = append(opts, NamespaceName(namespace))
+	}
+	apollo, err := initRemote(opts...)
+	if err != nil {
+		return nil, err
+	}
+	setInitStatus(apollo, "")
+	return Remote, nil
+}
+
+// initRemote Init apollo and the remote viper
+func initRemote(opts ...Option) (*Apollo, error) {
+	apollo := InitApollo(opts...)
+	if _, err := InitViperRemote(apollo, viper.KeyDelimiter(":")); err != nil {
+		return nil, err
+	}
+	return apollo, nil
 }
 
 // InitApollo initiate apollo with options which server, appId are mandatory.
 // e.g. InitApollo(vapollo.Server("127.0.0.1"), vapollo.AppID("TestApp"))
 func InitApollo(opts ...Option) *Apollo {
+	apollo, err := buildApollo(opts...)
+	if err != nil {
+		log.Panicln("Can't not init apollo,", err)
+		return nil
+	}
+	return apollo
+}
+
+// buildApollo Create an apollo with opts and validate it, the error describing
+// the first invalid argument
+func buildApollo(opts ...Option) (*Apollo, error) {
+	apollo := newApollo(opts...)
+	apollo.resolveServers()
+	if len(apollo.servers) == 0 || apollo.appID == "" {
+		return nil, ErrMissingArguments
+	}
+
+	if apollo.accessKey != nil {
+		if _, err := apollo.accessKey.get(); err != nil {
+			return nil, fmt.Errorf("failed reading access key: %w", err)
+		}
+	}
+
+	if err := apollo.initClient(); err != nil {
+		return nil, err
+	}
+	apollo.initNamespaces()
+	if err := apollo.checkNamespaces(); err != nil {
+		return nil, err
+	}
+	apollo.resumed = apollo.restoreNotifications()
+	apollo.snapshotStructDefaults()
+	if apollo.expvarPrefix != "" {
+		apollo.publishExpvar()
+	}
+
+	return apollo, nil
+}
+
+// newApollo Create an apollo with default parameters overridden by opts
+func newApollo(opts ...Option) *Apollo {
 	apollo := &Apollo{
+		baseCtx:       context.Background(),
 		cluster:       "default",
 		namespaceName: "application",
+		maxNamespaces: defaultMaxNamespaces,
+		logger:        log.Default(),
+		backoff: backoff{
+			min:        defaultMinBackoff,
+			max:        defaultMaxBackoff,
+			resetAfter: 1,
+		},
 	}
 	for _, opt := range opts {
 		opt.apply(apollo)
 	}
+	return apollo
+}
 
-	if apollo.server == "" || apollo.appID == "" {
-		log.Panicln("Can't not init apollo, missing arguments(server, appId)")
-		return nil
+// initNamespaces Resolve the namespaces loaded and watch them from the first
+// notification
+func (a *Apollo) initNamespaces() {
+	a.initSources()
+	if a.namespaceName == "" {
+		a.namespaceName = "application"
 	}
-
-	apollo.notifications = []notification{
-		{
-			NamespaceName:  apollo.namespaceName,
+	if len(a.namespaces) == 0 {
+		a.namespaces = []string{a.namespaceName}
+	}
+	a.namespaceName = a.namespaces[0]
+	for _, namespace := range a.namespaces {
+		a.notifications = append(a.notifications, notification{
+			NamespaceName:  namespace,
 			NotificationID: -1,
-		},
+		})
 	}
-
-	return apollo
 }
 
 var Remote *viper.Viper
@@ -204,37 +727,123 @@ var Remote *viper.Viper
 // Here viper.Options are exposed because if any keys of an app are in nested
 // style like "a.b", then viper can NOT read it correctly. So we can set the
 // KeyDelimiter option of viper to ':' or else instead of '.'
+// Configuration is read from apollo before returning, an error is returned if
+// it can't be read, so that the remote viper is populated on return. With Lazy
+// it is read on first access instead, and no viper is returned, see Lazy.
 func InitViperRemote(apollo *Apollo, opts ...viper.Option) (*viper.Viper, error) {
 	if apollo == nil {
 		log.Panicln("Can not init viper remote with apollo: Please check and init apollo first")
 	}
 
-	if !strings.Contains(apollo.server, "http") && !strings.Contains(apollo.server, "https") {
-		apollo.server = "http://" + apollo.server
-	}
 	viper.RemoteConfig = apollo
+	apollo.viperOptions = opts
+	v := viper.GetViper()
 	if len(opts) > 0 {
-		Remote = viper.NewWithOptions(opts...)
-	} else {
-		Remote = viper.GetViper()
+		v = viper.NewWithOptions(opts...)
 	}
 
-	err := Remote.AddRemoteProvider("consul", apollo.server, apollo.appID)
+	err := v.AddRemoteProvider("consul", apollo.currentServer(), apollo.appID)
 	if err != nil {
 		return nil, err
 	}
-	Remote.SetConfigType("json")
+	v.SetConfigType("json")
+	if apollo.lazy {
+		apollo.lazyRemote = v
+		return nil, nil
+	}
+	Remote = v
+	if err := apollo.start(); err != nil {
+		return nil, err
+	}
+	return Remote, nil
+}
+
+// start Read configurations from apollo, watch their modifications and map
+// them to the struct interface
+func (a *Apollo) start() error {
+	if a.strictStartup {
+		return a.strictStart()
+	}
+	if err := a.firstLoad(); err != nil && !(isFetchError(err) && a.restoreStruct(err)) {
+		return err
+	}
+	if a.afterReload != nil {
+		a.safely("AfterReload hook", func() { a.afterReload(Remote) })
+	}
 	// Watch modifications on remote
 	_ = Remote.WatchRemoteConfigOnChannel()
-	// Map values to object member if an object interface was provided
-	_ = apollo.ParseStruct(viper.AllSettings(), Remote.AllSettings())
-	return Remote, nil
+	a.bindRemote()
+	a.logger.Printf("Apollo remote initialized: %s", a.summary())
+	a.logEffectiveConfig()
+	return nil
+}
+
+// bindRemote Map settings of the remote viper to the struct interface, if
+// provided, and to subtree bindings
+func (a *Apollo) bindRemote() {
+	var local map[string]interface{}
+	if !a.ignoreLocal {
+		local = viper.AllSettings()
+		a.layerLocal(local)
+	}
+	remote := Remote.AllSettings()
+	_ = a.ParseStruct(local, remote)
+	a.bindSubtrees(remote)
+}
+
+// firstLoad Read configurations from apollo before watching them, so that
+// they are populated when InitViperRemote returns, and run the OnFirstLoad
+// hook on them
+func (a *Apollo) firstLoad() error {
+	standby, err := a.warmUp()
+	if !standby {
+		a.applyMu.Lock()
+		if err = Remote.ReadRemoteConfig(); err != nil {
+			// viper drops the error of the remote provider
+			if loadErr := a.loadError(); loadErr != nil {
+				err = loadErr
+			}
+		}
+		a.applyMu.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading apollo config: %w", err)
+	}
+	if a.onFirstLoad == nil {
+		return nil
+	}
+	return a.onFirstLoad(a.configCopy())
+}
+
+// summary Describe the resolved apollo coordinates, the access key is never
+// included
+func (a *Apollo) summary() string {
+	auth := "none"
+	if a.accessKey != nil {
+		auth = "access-key"
+		if a.accessKey.path != "" {
+			auth = "access-key-file(" + a.accessKey.path + ")"
+		}
+	}
+	cacheDir := a.cacheDir
+	if cacheDir == "" {
+		cacheDir = "none"
+	}
+	return fmt.Sprintf("server=%s appId=%s cluster=%s namespaces=[%s] auth=%s cache=%s watch=long-poll",
+		a.currentServer(), a.appID, a.currentCluster(), strings.Join(a.namespaces, ","), auth, cacheDir)
 }
 
 func (a *Apollo) Get(rp viper.RemoteProvider) (io.Reader, error) {
-	b, err := a.load()
-	r := bytes.NewReader(b)
-	return r, err
+	if b := a.takePromoted(); b != nil {
+		return bytes.NewReader(b), nil
+	}
+	if _, err := a.load(); err != nil {
+		a.setLoadError(err)
+		return bytes.NewReader(nil), err
+	}
+	a.setLoadError(nil)
+	b, err := a.json().Marshal(a.withDeletedKeys(a.configCopy()))
+	return bytes.NewReader(b), err
 }
 
 func (a *Apollo) Watch(rp viper.RemoteProvider) (io.Reader, error) {
@@ -243,48 +852,308 @@ func (a *Apollo) Watch(rp viper.RemoteProvider) (io.Reader, error) {
 	return r, err
 }
 
+// WatchChannel starts watching apollo for modifications, it is called by
+// viper. If a watcher is already running, its channels are returned.
 func (a *Apollo) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
-	ch := make(chan *viper.RemoteResponse)
-	quitCh := make(chan bool)
-	go func(vc chan<- *viper.RemoteResponse, quit <-chan bool) {
-		for {
-			select {
-			case <-quit:
-				return
-			default:
-				// get modification notify from apollo
-				modified, err := a.getNotifications()
-				if err != nil {
-					vc <- &viper.RemoteResponse{Error: err}
-					log.Printf("Watch remote channel error=%v", err)
-					continue
-				}
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	if a.responses == nil {
+		a.responses = make(chan *viper.RemoteResponse)
+	}
+	if a.quit == nil {
+		a.startWatch()
+	}
+	return a.responses, a.quit
+}
 
-				// read content if modified(notification with HTTP status 200)
-				if modified {
-					err = Remote.ReadRemoteConfig()
-					if err != nil {
-						log.Println("Failed reading apollo config: ", err)
-						continue
-					}
-					if a.object != nil {
-						settings := Remote.AllSettings()
-						log.Printf("All settings: %v", settings)
-						// Parse all settings to the struct interface provided
-						_ = a.ParseStruct(nil, settings)
-					}
-					if a.notify != nil {
-						a.notify <- true
+// StartWatch starts watching apollo for modifications, e.g. to restart the
+// watcher after StopWatch. It does nothing if a watcher is already running.
+// InitViperRemote must have been called before.
+func (a *Apollo) StartWatch() error {
+	if Remote == nil {
+		return errors.New("failed starting watch: viper remote not initialized")
+	}
+	if a.static != nil {
+		return errors.New("failed starting watch: static configuration is not watched")
+	}
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	if a.quit == nil {
+		a.startWatch()
+	}
+	return nil
+}
+
+// StopWatch stops the running watcher, if any. The watcher returns once the
+// pending notification poll completes.
+func (a *Apollo) StopWatch() {
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	if a.quit != nil {
+		close(a.quit)
+		a.quit = nil
+	}
+}
+
+// RunWatch runs the watcher until ctx is done, e.g. in an errgroup:
+//
+//	g.Go(func() error { return apollo.RunWatch(ctx) })
+//
+// It returns nil once ctx is done, or an error if the watcher stops before,
+// e.g. on StopWatch.
+func (a *Apollo) RunWatch(ctx context.Context) error {
+	if err := a.StartWatch(); err != nil {
+		return err
+	}
+	a.watchMu.Lock()
+	stopped := a.stopped
+	a.watchMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		a.StopWatch()
+		return nil
+	case <-stopped:
+		return errors.New("apollo watcher stopped")
+	}
+}
+
+// startWatch Start the watch loop, watchMu must be held
+func (a *Apollo) startWatch() {
+	previous := a.stopped
+	a.quit = make(chan bool)
+	a.stopped = make(chan struct{})
+	go a.watch(a.responses, a.quit, a.stopped, previous)
+}
+
+// watch Poll apollo notifications and apply modifications until quit. The
+// loop starts once the previous watcher, if any, returned, as StopWatch
+// doesn't wait for it.
+func (a *Apollo) watch(vc chan<- *viper.RemoteResponse, quit chan bool, stopped chan struct{}, previous chan struct{}) {
+	defer func() {
+		a.watchMu.Lock()
+		if a.quit == quit {
+			a.quit = nil
+		}
+		a.watchMu.Unlock()
+		close(stopped)
+	}()
+	if previous != nil {
+		select {
+		case <-previous:
+		case <-quit:
+			return
+		}
+	}
+	done := a.baseCtx.Done()
+	if a.staleAfter > 0 {
+		go a.watchStale(quit, done)
+	}
+	if !a.waitStartDelay(quit, done) {
+		return
+	}
+	// Resumed notification ids don't report the namespaces as modified,
+	// configuration is read once before polling instead
+	if a.resumed {
+		a.resumed = false
+		if !a.deferChange() {
+			a.applyChange()
+		}
+	}
+	for {
+		select {
+		case <-quit:
+			return
+		case <-done:
+			return
+		default:
+			// get modification notify from apollo
+			modified, err := a.getNotifications()
+			if err != nil {
+				if a.baseCtx.Err() != nil {
+					return
+				}
+				delay := a.backoff.failure()
+				a.recordPoll(false, err, delay)
+				if a.backoff.exhausted() {
+					a.retriesExhausted(err)
+				}
+				a.logger.Printf("Watch remote channel error=%v, retrying in %v", err, delay)
+				if vc != nil {
+					select {
+					case vc <- &viper.RemoteResponse{Error: err}:
+					case <-quit:
+						return
+					case <-done:
+						return
 					}
 				}
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-quit:
+					timer.Stop()
+					return
+				case <-done:
+					timer.Stop()
+					return
+				}
+				continue
+			}
+			a.backoff.success()
+			a.recordPoll(modified, nil, a.backoff.current)
+
+			// read content if modified(notification with HTTP status 200), or
+			// refresh it if it wasn't read for the max poll interval
+			refresh := !modified && a.refreshDue()
+			if (modified || refresh) && !a.deferChange() {
+				if !a.waitMinPollInterval(quit, done) {
+					return
+				}
+				a.apply(refresh)
+			}
+		}
+	}
+}
+
+// Pause stops applying modifications and firing notifications, while the
+// watcher keeps polling apollo. Modifications received while paused are
+// coalesced and the latest configuration is applied once on Resume.
+func (a *Apollo) Pause() {
+	a.pauseMu.Lock()
+	a.paused = true
+	a.pauseMu.Unlock()
+}
+
+// Resume applies the latest configuration if it was modified while paused,
+// then resumes applying modifications as they come
+func (a *Apollo) Resume() {
+	a.pauseMu.Lock()
+	pending := a.pending
+	a.paused, a.pending = false, false
+	a.pauseMu.Unlock()
+	if pending {
+		a.applyChange()
+	}
+}
+
+// deferChange Record a modification and report true if the watcher is paused
+func (a *Apollo) deferChange() bool {
+	a.pauseMu.Lock()
+	defer a.pauseMu.Unlock()
+	if a.paused {
+		a.pending = true
+	}
+	return a.paused
+}
+
+// applyChange Read modified configuration from apollo and deliver it to the
+// struct interface, the OnChange callback and the notify channel. A panic in
+// any of these steps is recovered and logged, so that the watch loop keeps
+// running.
+func (a *Apollo) applyChange() {
+	a.apply(false)
+}
+
+// apply Read configuration from apollo and deliver it as applyChange does. If
+// onlyIfChanged, nothing is delivered unless the configuration changed.
+func (a *Apollo) apply(onlyIfChanged bool) {
+	if standby, err := a.warmUp(); standby {
+		if err != nil {
+			a.logger.Printf("Failed reading apollo config in standby: %v", err)
+		}
+		return
+	}
+	a.applyMu.Lock()
+	defer a.applyMu.Unlock()
+	a.configMu.RLock()
+	previous := a.config
+	a.configMu.RUnlock()
+	err := Remote.ReadRemoteConfig()
+	a.lastApply = time.Now()
+	if err != nil {
+		a.logger.Printf("Failed reading apollo config: %v", err)
+		return
+	}
+	a.setDegraded(false)
+	if onlyIfChanged {
+		a.configMu.RLock()
+		unchanged := reflect.DeepEqual(previous, a.config)
+		a.configMu.RUnlock()
+		if unchanged {
+			return
+		}
+	}
+	if a.afterReload != nil {
+		a.safely("AfterReload hook", func() { a.afterReload(Remote) })
+	}
+	settings := Remote.AllSettings()
+	if a.object != nil {
+		a.logger.Printf("All settings: %v", settings)
+		// Parse all settings to the struct interface provided
+		a.safely("parsing struct", func() {
+			_ = a.ParseStruct(nil, settings)
+		})
+	}
+	if deleted := a.takeDeletedKeys(); len(deleted) > 0 && a.object != nil {
+		a.safely("resetting deleted keys", func() {
+			if err := a.resetDeletedFields(deleted); err != nil {
+				a.logger.Printf("Reset deleted keys with error=%v", err)
 			}
+		})
+	}
+	if len(a.subtrees) > 0 {
+		a.safely("binding subtrees", func() {
+			a.bindSubtrees(settings)
+		})
+	}
+	if a.onChange != nil {
+		a.runCallback("OnChange callback", a.onChange)
+	}
+	if changed := a.takeChangedNamespaces(); len(changed) > 0 && a.onBatchChange != nil {
+		a.runCallback("OnBatchChange callback", func() { a.onBatchChange(changed) })
+	}
+	if a.notify != nil {
+		a.safely("notifying", func() {
+			a.notify <- true
+		})
+	}
+}
+
+// safely Run fn and recover from any panic it raises
+func (a *Apollo) safely(step string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Printf("Recovered from panic while %s: %v", step, r)
 		}
-	}(ch, quitCh)
-	return ch, quitCh
+	}()
+	fn()
 }
 
-func (a *Apollo) getNotificationsBody() string {
-	b, err := json.Marshal(a.notifications)
+// maxNotifications bounds the notifications sent in a long-poll request
+const maxNotifications = 1000
+
+// getNotificationsBody Encode notifications for a long-poll request,
+// de-duplicated by namespace keeping the latest id, and at most
+// maxNotifications of them
+func (a *Apollo) getNotificationsBody(watched []notification) string {
+	notifications := make([]notification, 0, len(watched))
+	seen := map[string]int{}
+	for _, n := range watched {
+		if i, ok := seen[n.NamespaceName]; ok {
+			if n.NotificationID > notifications[i].NotificationID {
+				notifications[i].NotificationID = n.NotificationID
+			}
+			continue
+		}
+		seen[n.NamespaceName] = len(notifications)
+		notifications = append(notifications, n)
+	}
+	if len(notifications) > maxNotifications {
+		a.logger.Printf("Too many apollo namespaces to watch, only the first %d are watched", maxNotifications)
+		notifications = notifications[:maxNotifications]
+	}
+	b, err := a.json().Marshal(notifications)
 	if err != nil {
 		return ""
 	}
@@ -292,100 +1161,435 @@ func (a *Apollo) getNotificationsBody() string {
 }
 
 func (a *Apollo) loadFromCache() ([]byte, error) {
-	uri := fmt.Sprintf(
-		"%s/configfiles/json/%s/%s/%s",
-		a.server,
-		a.appID,
-		a.cluster,
-		a.namespaceName,
-	)
+	configs := make([]map[string]interface{}, 0, len(a.namespaces))
+	for _, namespace := range a.namespaces {
+		_, b, err := a.fetch(a.baseCtx, a.namespacePath("configfiles/json", namespace))
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := a.configurations(namespace, b)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	merged, err := a.mergeConfigs(configs)
+	if err != nil {
+		return nil, err
+	}
+	return a.json().Marshal(merged)
+}
+
+// namespacePath Return the path of endpoint serving namespace, segments are
+// escaped so that any app, cluster or namespace name makes a valid path
+func (a *Apollo) namespacePath(endpoint, namespace string) string {
+	s := a.sourceOf(namespace)
+	path := fmt.Sprintf("/%s/%s/%s/%s", endpoint,
+		url.PathEscape(s.AppID), url.PathEscape(s.Cluster), url.PathEscape(s.Namespace))
+	if params := a.grayParams(); len(params) > 0 {
+		path = path + "?" + params.Encode()
+	}
+	return path
+}
 
+// grayParams Return the query parameters targeting gray releases
+func (a *Apollo) grayParams() url.Values {
 	params := url.Values{}
 	if a.ip != "" {
-		params.Add("ip", a.ip)
-		uri = uri + "?" + params.Encode()
+		params.Set("ip", a.ip)
 	}
-	return a.get(uri)
+	for k, v := range a.labels {
+		params.Set(k, v)
+	}
+	return params
 }
 
 func (a *Apollo) load() ([]byte, error) {
-	uri := fmt.Sprintf(
-		"%s/configs/%s/%s/%s",
-		a.server,
-		a.appID,
-		a.cluster,
-		a.namespaceName,
-	)
+	loads, source, err := a.loadNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	if a.static != nil {
+		source = SourceStatic
+	}
+	configs := make([]map[string]interface{}, len(loads))
+	for i, l := range loads {
+		if l != nil {
+			configs[i] = l.cfg
+		}
+	}
+	merged, err := a.mergeConfigs(configs)
+	if err != nil {
+		return nil, err
+	}
+	if a.schemaValidator != nil {
+		if err := a.schemaValidator(merged); err != nil {
+			err = fmt.Errorf("invalid configuration, keeping the previous one: %w", err)
+			a.logger.Printf("%v", err)
+			if a.onError != nil {
+				a.safely("OnError callback", func() { a.onError(err) })
+			}
+			return nil, err
+		}
+	}
+	for _, l := range loads {
+		if l != nil {
+			a.commitNamespace(l)
+		}
+	}
+	a.setConfig(merged, source)
+	return a.json().Marshal(merged)
+}
 
-	params := url.Values{}
-	if a.ip != "" {
-		params.Add("ip", a.ip)
-		uri = uri + "?" + params.Encode()
+// namespaceLoad is the configuration of a namespace loaded from apollo or the
+// disk cache, not applied yet
+type namespaceLoad struct {
+	namespace string
+	cfg       map[string]interface{}
+	source    ConfigSource
+	// raw is the configuration to write to the disk cache, nil if it was
+	// read from it
+	raw        []byte
+	releaseKey string
+	// cluster is the cluster which served the configuration, which differs
+	// from the requested one when apollo fell back to another cluster
+	cluster string
+	// content is the configuration as served by apollo or the disk cache,
+	// before any transformation
+	content []byte
+}
+
+// loadNamespace Load configurations of namespace, falling back to the disk
+// cache if apollo can't be reached
+func (a *Apollo) loadNamespace(ctx context.Context, namespace string) (*namespaceLoad, error) {
+	defer a.beginReload()()
+	if a.static != nil {
+		return a.loadStatic(namespace)
+	}
+	l := &namespaceLoad{namespace: namespace, source: SourceRemote}
+	var b []byte
+	resp, err := a.get(ctx, a.namespacePath("configs", namespace))
+	if err != nil {
+		cached, cacheErr := a.readCache(namespace)
+		if cacheErr != nil {
+			return nil, &fetchError{err}
+		}
+		a.logger.Printf("Failed loading apollo config of %s, using disk cache: %v", namespace, err)
+		b, l.source = cached, SourceCache
+	} else {
+		b, l.raw, l.releaseKey, l.cluster = resp.Configurations, resp.Configurations, resp.ReleaseKey, resp.Cluster
 	}
+	if l.cfg, err = a.configurations(namespace, b); err != nil {
+		return nil, err
+	}
+	l.content = b
+	return l, nil
+}
+
+// commitNamespace Apply the loaded configuration of a namespace: write it to
+// the disk cache, record its release key and update its viper
+func (a *Apollo) commitNamespace(l *namespaceLoad) {
+	if l.raw != nil {
+		if err := a.writeCache(l.namespace, l.raw); err != nil {
+			a.logger.Printf("Failed writing disk cache: %v", err)
+		}
+	}
+	if l.cluster != "" && l.namespace == a.namespaceName {
+		a.releaseKeysMu.Lock()
+		a.activeCluster = l.cluster
+		a.releaseKeysMu.Unlock()
+	}
+	if l.releaseKey != "" {
+		released := l.releaseKey != a.releaseKey(l.namespace)
+		a.setReleaseKey(l.namespace, l.releaseKey)
+		if released && a.keyMetadata && a.portal != "" {
+			a.refreshKeyMeta(l.namespace)
+		}
+	}
+	a.setNamespaceConfig(l.namespace, l.cfg, l.content)
+}
 
-	return a.get(uri)
+// LastResponseHeaders returns a copy of the headers of the last configuration
+// response(/configs or /configfiles) received from apollo, or nil if no
+// configuration has been fetched yet. Responses of the notification long-poll
+// are not recorded.
+func (a *Apollo) LastResponseHeaders() http.Header {
+	a.lastHeaderMu.RLock()
+	defer a.lastHeaderMu.RUnlock()
+	return a.lastHeader.Clone()
 }
 
-// get Read content of the specified appId from apollo
-func (a *Apollo) get(uri string) ([]byte, error) {
-	resp, err := http.Get(uri)
+// do sends a signed GET request for path to apollo with header, trying the
+// servers of the pool in order until one answers
+func (a *Apollo) do(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	var lastErr error
+	for _, server := range a.serverPool() {
+		resp, err := a.send(ctx, server+path, header)
+		if err == nil {
+			a.setServer(server)
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// send Send a signed GET request to uri
+func (a *Apollo) send(ctx context.Context, uri string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if err := a.sign(req); err != nil {
+		return nil, err
+	}
+	for _, mutate := range a.requestMutators {
+		if err := mutate(req); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.throttle(req.Context()); err != nil {
+		return nil, err
+	}
+	return a.client.Do(req)
+}
 
+// fetch Read a configuration response from apollo, recording its headers.
+// The request is conditional if the last response for path carried an ETag
+// or Last-Modified header, and the kept body is returned if apollo answers it
+// is not modified.
+func (a *Apollo) fetch(ctx context.Context, path string) (*http.Response, []byte, error) {
+	resp, err := a.do(ctx, path, a.conditionalHeader(path))
+	if err != nil {
+		return nil, nil, err
+	}
 	defer resp.Body.Close()
 
-	b, err := ioutil.ReadAll(resp.Body)
+	a.lastHeaderMu.Lock()
+	a.lastHeader = resp.Header.Clone()
+	a.lastHeaderMu.Unlock()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if v, ok := a.validatedResponse(path); ok {
+			return resp, v.body, nil
+		}
+	}
+	b, err := a.readBody(resp.Body)
 	if err != nil {
-		return nil, err
+		return resp, nil, err
 	}
+	a.keepResponse(path, resp, b)
+	return resp, b, nil
+}
 
+// get Read the response of the specified appId and namespace from apollo
+func (a *Apollo) get(ctx context.Context, path string) (apolloResponse, error) {
+	resp, b, err := a.fetch(ctx, path)
+	if err != nil {
+		return apolloResponse{}, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		if v, ok := a.validatedResponse(path); ok && v.decoded != nil {
+			return *v.decoded, nil
+		}
+	}
+
+	apolloResp, err := a.decodeResponse(b)
+	if err != nil {
+		return apolloResp, newInvalidResponse(resp, b, err)
+	}
+	a.keepDecoded(path, b, apolloResp)
+	return apolloResp, nil
+}
+
+// decodeResponse Decode a configuration response, reading configurations
+// from the field set by ConfigurationsKey
+func (a *Apollo) decodeResponse(b []byte) (apolloResponse, error) {
 	var apolloResp apolloResponse
-	if err := json.Unmarshal(b, &apolloResp); err != nil {
-		return nil, err
+	key := a.configurationsKey
+	var configurations json.RawMessage
+	if key != "" && key != "configurations" {
+		fields := map[string]json.RawMessage{}
+		if err := a.json().Unmarshal(b, &fields); err != nil {
+			return apolloResp, err
+		}
+		var ok bool
+		if configurations, ok = fields[key]; !ok {
+			return apolloResp, fmt.Errorf("missing configurations field %q", key)
+		}
+		delete(fields, key)
+		b, _ = a.json().Marshal(fields)
+	}
+
+	if a.strictResponse {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&apolloResp); err != nil {
+			return apolloResp, err
+		}
+	} else if err := a.json().Unmarshal(b, &apolloResp); err != nil {
+		return apolloResp, err
+	}
+	if configurations != nil {
+		apolloResp.Configurations = configurations
 	}
+	return apolloResp, nil
+}
+
+// releaseCheckInterval is the interval at which WaitForRelease checks the
+// loaded release key
+const releaseCheckInterval = 200 * time.Millisecond
 
-	a.releaseKey = apolloResp.ReleaseKey
-	return apolloResp.Configurations, nil
+// WaitForRelease blocks until the configuration released as releaseKey has
+// been loaded from apollo, or ctx is done. It is meant to confirm a published
+// release is applied, e.g. before marking a deploy healthy.
+func (a *Apollo) WaitForRelease(ctx context.Context, releaseKey string) error {
+	ticker := time.NewTicker(releaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, namespace := range a.namespaces {
+			if a.releaseKey(namespace) == releaseKey {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed waiting for release %s: %w", releaseKey, ctx.Err())
+		case <-ticker.C:
+		}
+	}
 }
 
-// getNotifications Read notification of the specified appId from apollo
+// releaseKey Return the release key last loaded for namespace
+// ActiveCluster returns the cluster which served the first namespace in the
+// last successful load, which differs from the cluster set by Cluster when
+// apollo fell back to another cluster, e.g. default. It is the requested
+// cluster until a load succeeded or if apollo doesn't report it.
+func (a *Apollo) ActiveCluster() string {
+	a.releaseKeysMu.RLock()
+	defer a.releaseKeysMu.RUnlock()
+	if a.activeCluster == "" {
+		return a.sourceOf(a.namespaceName).Cluster
+	}
+	return a.activeCluster
+}
+
+func (a *Apollo) releaseKey(namespace string) string {
+	a.releaseKeysMu.RLock()
+	defer a.releaseKeysMu.RUnlock()
+	return a.releaseKeys[namespace]
+}
+
+func (a *Apollo) setReleaseKey(namespace, releaseKey string) {
+	a.releaseKeysMu.Lock()
+	defer a.releaseKeysMu.Unlock()
+	if a.releaseKeys == nil {
+		a.releaseKeys = map[string]string{}
+	}
+	a.releaseKeys[namespace] = releaseKey
+}
+
+// getNotifications Read notifications of the watched namespaces from apollo,
+// report whether any was modified
 func (a *Apollo) getNotifications() (bool, error) {
-	params := url.Values{}
-	params.Add("appId", a.appID)
-	params.Add("cluster", a.cluster)
-	params.Add("notifications", a.getNotificationsBody())
-	resp, err := http.Get(fmt.Sprintf(
-		"%s/notifications/v2?%s",
-		a.server,
-		params.Encode(),
-	))
-	if err != nil {
+	groups := a.notificationGroups()
+	if len(groups) > 1 {
+		return a.pollGroups(groups)
+	}
+	changed, err := a.pollNotifications(a.baseCtx, groups[0])
+	if changed == nil {
 		return false, err
 	}
+	a.updateNotifications(changed)
+	return true, nil
+}
+
+// pollNotifications Read notifications of the namespaces of g from apollo,
+// named after the namespaces of this instance, nil if none was modified
+func (a *Apollo) pollNotifications(ctx context.Context, g *notificationGroup) ([]notification, error) {
+	params := a.grayParams()
+	params.Add("appId", g.appID)
+	params.Add("cluster", g.cluster)
+	body := a.getNotificationsBody(g.notifications)
+	params.Add("notifications", body)
+	var header http.Header
+	validator := ""
+	if a.conditionalNotifications {
+		validator = notificationsValidator(body)
+		header = http.Header{"If-None-Match": {validator}}
+	}
+	resp, err := a.do(ctx, "/notifications/v2?"+params.Encode(), header)
+	if err != nil {
+		return nil, err
+	}
 
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNotModified {
-		return false, nil
+	if a.notModified(resp.StatusCode, nil) {
+		return nil, nil
+	}
+	if validator != "" && resp.Header.Get("ETag") == validator {
+		// the backend echoed the validator, the body isn't worth reading
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, nil
 	}
 
-	b, err := ioutil.ReadAll(resp.Body)
+	b, err := a.readBody(resp.Body)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	if a.notModified(resp.StatusCode, b) {
+		return nil, nil
+	}
+	var changed []notification
+	if err := a.json().Unmarshal(b, &changed); err != nil {
+		return nil, newInvalidResponse(resp, b, err)
 	}
-	err = json.Unmarshal(b, &a.notifications)
-	return true, err
+	renamed := make([]notification, 0, len(changed))
+	for _, c := range changed {
+		if name, ok := g.names[c.NamespaceName]; ok {
+			renamed = append(renamed, notification{NamespaceName: name, NotificationID: c.NotificationID})
+		}
+	}
+	return renamed, nil
 }
 
+// isJSONArray Report whether s holds a json array to decode into t, a slice or
+// an array other than of bytes
+func isJSONArray(s string, t reflect.Value) bool {
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return false
+	}
+	if t.Type().Elem().Kind() == reflect.Uint8 {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(s), "[")
+}
+
+// JsonStructInMapHookFunc decodes json strings into structs and maps, json
+// array strings into slices, e.g. of structs, and numeric strings into numbers
 func JsonStructInMapHookFunc() mapstructure.DecodeHookFunc {
+	return jsonStructInMapHookFunc(json.Unmarshal)
+}
+
+// jsonStructInMapHookFunc JsonStructInMapHookFunc decoding json with unmarshal
+func jsonStructInMapHookFunc(unmarshal func(data []byte, v interface{}) error) mapstructure.DecodeHookFunc {
 	return func(f reflect.Value, t reflect.Value) (interface{}, error) {
 		if f.Kind() == reflect.String && (t.Kind() == reflect.Struct || t.Kind() == reflect.Map) {
 			o := map[string]interface{}{}
-			err := json.Unmarshal([]byte(f.String()), &o)
+			err := unmarshal([]byte(f.String()), &o)
 			if err != nil {
 				return f.Interface(), err
 			}
 			return o, nil
+		} else if f.Kind() == reflect.String && isJSONArray(f.String(), t) {
+			var o []interface{}
+			if err := unmarshal([]byte(f.String()), &o); err != nil {
+				return f.Interface(), err
+			}
+			return o, nil
 		} else if f.Kind() == reflect.String && t.Kind() != reflect.String {
 			result := reflect.New(t.Type()).Interface()
 			result, err := strconv.Atoi(f.String())
@@ -398,24 +1602,46 @@ func JsonStructInMapHookFunc() mapstructure.DecodeHookFunc {
 	}
 }
 
+// Rebind decodes the settings of the remote viper into the struct interface
+// and the subtree bindings again, without reading apollo, e.g. after
+// overriding keys with Remote.Set.
+func (a *Apollo) Rebind() error {
+	if Remote == nil {
+		return errors.New("failed rebinding struct: viper remote not initialized")
+	}
+	a.applyMu.Lock()
+	defer a.applyMu.Unlock()
+	settings := Remote.AllSettings()
+	if len(a.subtrees) > 0 {
+		a.bindSubtrees(settings)
+		if a.object == nil {
+			return nil
+		}
+	}
+	return a.ParseStruct(nil, settings)
+}
+
+// ParseStruct decodes local then remote settings into the struct interface,
+// or remote then local settings with LocalPrecedence.
+// Settings are decoded into a copy of the struct which replaces it only once
+// remote settings decoded successfully, so that a failed parse never leaves
+// the struct partially updated. With DecodeTimeout, a decode running longer
+// fails too.
 func (a *Apollo) ParseStruct(local map[string]interface{}, remote map[string]interface{}) error {
 	if a.object == nil {
 		return errors.New("failed parsing struct: no interface")
 	}
-	deCfg := &mapstructure.DecoderConfig{
-		DecodeHook: JsonStructInMapHookFunc(),
-		Result:     a.object,
-	}
-	d, _ := mapstructure.NewDecoder(deCfg)
-	if local != nil {
-		err := d.Decode(local)
-		if err != nil {
-			log.Printf("Read LOCAL config with error=%v", err)
-		}
+	target := reflect.ValueOf(a.object)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("failed parsing struct: interface must be a non-nil pointer")
 	}
-	err := d.Decode(remote)
-	if err != nil {
-		log.Printf("Read REMOTE config with error=%v", err)
+	fresh := reflect.New(target.Elem().Type())
+	fresh.Elem().Set(deepCopy(target.Elem()))
+
+	if err := a.decodeStructWithin(fresh.Interface(), local, remote); err != nil {
+		return err
 	}
-	return err
+	target.Elem().Set(fresh.Elem())
+	a.saveStruct()
+	return nil
 }
@@ -0,0 +1,738 @@
+// Copyright © 2022 Carwyn Kong <kong__mo@163.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package vapollo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var discard = log.New(io.Discard, "", 0)
+
+// newFakeApollo starts a server answering /configs with configurations and
+// reporting a modification on every notification poll
+func newFakeApollo(configurations string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":` + configurations + `,"releaseKey":"r1"}`))
+	})
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"namespaceName":"application","notificationId":1}]`))
+	})
+	return httptest.NewServer(mux)
+}
+
+// useRemote points the package level Remote viper to apollo
+func useRemote(t *testing.T, a *Apollo) {
+	t.Helper()
+	Remote = viper.New()
+	viper.RemoteConfig = a
+	if err := Remote.AddRemoteProvider("consul", a.server, a.appID); err != nil {
+		t.Fatalf("add remote provider: %v", err)
+	}
+	Remote.SetConfigType("json")
+}
+
+// stopWatch stops the watcher of a and waits for it to return, so that it
+// doesn't use the package level Remote viper once the next test replaced it
+func stopWatch(a *Apollo) {
+	a.watchMu.Lock()
+	stopped := a.stopped
+	a.watchMu.Unlock()
+	a.StopWatch()
+	if stopped != nil {
+		<-stopped
+	}
+}
+
+func TestLastResponseHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace-Id", "trace-1")
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"},"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"))
+	if h := a.LastResponseHeaders(); h != nil {
+		t.Fatalf("expected nil headers before any request, got %v", h)
+	}
+
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	h := a.LastResponseHeaders()
+	if got := h.Get("X-Trace-Id"); got != "trace-1" {
+		t.Fatalf("expected X-Trace-Id trace-1, got %q", got)
+	}
+
+	h.Set("X-Trace-Id", "changed")
+	if got := a.LastResponseHeaders().Get("X-Trace-Id"); got != "trace-1" {
+		t.Fatalf("stored headers modified through returned copy, got %q", got)
+	}
+}
+
+func TestWatchChannelSurvivesPanickingCallback(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	var calls int32
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard), OnChange(func() {
+		atomic.AddInt32(&calls, 1)
+		panic("broken callback")
+	}))
+	useRemote(t, a)
+
+	ch, _ := a.WatchChannel(nil)
+	defer stopWatch(a)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&calls) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("watcher stopped after a panicking callback, calls=%d", atomic.LoadInt32(&calls))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartStopWatch(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	var calls int32
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard), OnChange(func() {
+		atomic.AddInt32(&calls, 1)
+	}))
+	useRemote(t, a)
+
+	waitCalls := func(n int32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for atomic.LoadInt32(&calls) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected at least %d changes, got %d", n, atomic.LoadInt32(&calls))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	quit := a.quit
+	if err := a.StartWatch(); err != nil || a.quit != quit {
+		t.Fatalf("StartWatch on a running watcher should be a no-op, err=%v", err)
+	}
+	waitCalls(1)
+
+	stopWatch(a)
+	time.Sleep(50 * time.Millisecond)
+	stopped := atomic.LoadInt32(&calls)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != stopped {
+		t.Fatalf("watcher still running after StopWatch, %d != %d", got, stopped)
+	}
+
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	defer stopWatch(a)
+	waitCalls(stopped + 1)
+}
+
+func TestInvalidResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("<html>" + strings.Repeat("x", 500) + "</html>"))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"))
+	_, err := a.load()
+	var invalid *ErrInvalidResponse
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected ErrInvalidResponse, got %v", err)
+	}
+	if invalid.StatusCode != http.StatusBadGateway || invalid.ContentType != "text/html" {
+		t.Fatalf("unexpected status/content-type %d %q", invalid.StatusCode, invalid.ContentType)
+	}
+	if len(invalid.Body) != invalidResponseBodySize || !strings.HasPrefix(string(invalid.Body), "<html>") {
+		t.Fatalf("unexpected body %q", invalid.Body)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	var calls int32
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard), OnChange(func() {
+		atomic.AddInt32(&calls, 1)
+	}))
+	useRemote(t, a)
+
+	a.Pause()
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	defer stopWatch(a)
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Fatalf("expected no change applied while paused, got %d", got)
+	}
+
+	a.Resume()
+	if got := atomic.LoadInt32(&calls); got < 1 {
+		t.Fatalf("expected pending change applied on Resume, got %d", got)
+	}
+}
+
+func TestReleaseKeyPerNamespace(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns := path.Base(r.URL.Path)
+		_, _ = w.Write([]byte(`{"appId":"app","namespaceName":"` + ns + `","configurations":{},"releaseKey":"rk-` + ns + `"}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Namespaces("application", "common"))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, ns := range []string{"application", "common"} {
+		if got := a.releaseKey(ns); got != "rk-"+ns {
+			t.Fatalf("expected release key rk-%s, got %q", ns, got)
+		}
+	}
+}
+
+func TestSummaryRedactsAccessKey(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1:8080"), AppId("app"), AccessKey("s3cr3t"))
+	s := a.summary()
+	for _, want := range []string{"server=http://127.0.0.1:8080", "appId=app", "cluster=default", "namespaces=[application]", "auth=access-key"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("summary %q misses %q", s, want)
+		}
+	}
+	if strings.Contains(s, "s3cr3t") {
+		t.Fatalf("summary %q leaks the access key", s)
+	}
+}
+
+func TestInitFromEnvRequiresCoordinates(t *testing.T) {
+	defer os.Setenv("APOLLO_SERVER", os.Getenv("APOLLO_SERVER"))
+	os.Setenv("APOLLO_SERVER", "")
+	if _, err := InitFromEnv(nil); err == nil {
+		t.Fatal("expected an error without APOLLO_SERVER")
+	}
+}
+
+func TestWaitForRelease(t *testing.T) {
+	a := InitApollo(Server("http://127.0.0.1"), AppId("app"))
+	a.setReleaseKey("application", "r1")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		a.setReleaseKey("application", "r2")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := a.WaitForRelease(ctx, "r2"); err != nil {
+		t.Fatalf("WaitForRelease: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := a.WaitForRelease(ctx, "r3"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestGrayParams(t *testing.T) {
+	var configsQuery, notificationsQuery url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/notifications/v2" {
+			notificationsQuery = r.URL.Query()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		configsQuery = r.URL.Query()
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{}}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), IP("10.0.0.1"), Labels(map[string]string{"label": "canary"}))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := a.getNotifications(); err != nil {
+		t.Fatalf("getNotifications: %v", err)
+	}
+	for _, q := range []url.Values{configsQuery, notificationsQuery} {
+		if q.Get("ip") != "10.0.0.1" || q.Get("label") != "canary" {
+			t.Fatalf("unexpected query %v", q)
+		}
+	}
+	if notificationsQuery.Get("appId") != "app" {
+		t.Fatalf("unexpected notifications query %v", notificationsQuery)
+	}
+}
+
+func TestStrictResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{},"schemaVersion":2}`))
+	}))
+	defer ts.Close()
+
+	if _, err := InitApollo(Server(ts.URL), AppId("app")).load(); err != nil {
+		t.Fatalf("unknown fields should be ignored by default, got %v", err)
+	}
+	_, err := InitApollo(Server(ts.URL), AppId("app"), StrictResponse()).load()
+	var invalid *ErrInvalidResponse
+	if !errors.As(err, &invalid) || !strings.Contains(err.Error(), "schemaVersion") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestSubEnv(t *testing.T) {
+	v := viper.New()
+	v.Set("apollo.dev.ip", "127.0.0.1")
+
+	sub, err := subEnv(v, "apollo", "dev")
+	if err != nil {
+		t.Fatalf("subEnv: %v", err)
+	}
+	if got := sub.GetString("ip"); got != "127.0.0.1" {
+		t.Fatalf("unexpected ip %q", got)
+	}
+	if _, err := subEnv(v, "", "dev"); err == nil {
+		t.Fatal("expected an error for an env missing at the top level")
+	}
+	if _, err := subEnv(v, "apollo", "staging"); err == nil || err.Error() != `env "staging" not found in local config under "apollo"` {
+		t.Fatalf("expected an error naming the missing env, got %v", err)
+	}
+	v.Set("dev", "not a subtree")
+	if _, err := subEnv(v, "", "dev"); err == nil || err.Error() != `env "dev" not found in local config` {
+		t.Fatalf("expected an error for an env which isn't a subtree, got %v", err)
+	}
+}
+
+func TestCheckEnv(t *testing.T) {
+	o := initOptions{}
+	RequireEnv()(&o)
+	AllowedEnvs("dev", "qa")(&o)
+	if err := o.checkEnv("prod", false); err == nil {
+		t.Fatal("expected an error for a defaulted env")
+	}
+	if err := o.checkEnv("prod", true); err == nil || !strings.Contains(err.Error(), `"prod"`) {
+		t.Fatalf("expected an error for an env not allowed, got %v", err)
+	}
+	if err := o.checkEnv("dev", true); err != nil {
+		t.Fatalf("checkEnv: %v", err)
+	}
+	if err := (initOptions{}).checkEnv("prod", false); err != nil {
+		t.Fatalf("expected any env accepted by default, got %v", err)
+	}
+}
+
+func TestConfigurationsKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","data":{"a":"1"},"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), ConfigurationsKey("data"), StrictResponse())
+	b, err := a.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if string(b) != `{"a":"1"}` || a.releaseKey("application") != "r1" {
+		t.Fatalf("unexpected configurations %s, release key %q", b, a.releaseKey("application"))
+	}
+
+	a = InitApollo(Server(ts.URL), AppId("app"), ConfigurationsKey("content"))
+	if _, err := a.load(); err == nil || !strings.Contains(err.Error(), `"content"`) {
+		t.Fatalf("expected a missing field error, got %v", err)
+	}
+}
+
+func TestRunWatch(t *testing.T) {
+	ts := newFakeApollo(`{"a":"1"}`)
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	useRemote(t, a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.RunWatch(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expected nil on cancellation, got %v", err)
+	}
+
+	go func() {
+		done <- a.RunWatch(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	stopWatch(a)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error when the watcher stops before ctx is done")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunWatch did not return after StopWatch")
+	}
+}
+
+func TestIgnoreLocalSettings(t *testing.T) {
+	ts := newFakeApollo(`{}`)
+	defer ts.Close()
+	viper.Set("env", "dev")
+	defer viper.Reset()
+
+	type config struct {
+		Env string `mapstructure:"env"`
+	}
+	for _, ignore := range []bool{false, true} {
+		cfg := config{}
+		opts := []Option{Server(ts.URL), AppId("app"), Struct(&cfg), WithLogger(discard)}
+		if ignore {
+			opts = append(opts, IgnoreLocalSettings())
+		}
+		a := InitApollo(opts...)
+		if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+			t.Fatalf("InitViperRemote: %v", err)
+		}
+		stopWatch(a)
+		if want := map[bool]string{false: "dev", true: ""}[ignore]; cfg.Env != want {
+			t.Fatalf("ignore=%v: expected env %q, got %q", ignore, want, cfg.Env)
+		}
+	}
+}
+
+func TestOnFirstLoad(t *testing.T) {
+	ts := newFakeApollo(`{"config.schema":"v2"}`)
+	defer ts.Close()
+	defer viper.Reset()
+
+	var schema interface{}
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard),
+		OnFirstLoad(func(cfg map[string]interface{}) error {
+			schema = cfg["config.schema"]
+			return nil
+		}))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	stopWatch(a)
+	if schema != "v2" {
+		t.Fatalf("expected hook to see config.schema v2, got %v", schema)
+	}
+
+	abort := errors.New("unsupported schema")
+	a = InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard),
+		OnFirstLoad(func(cfg map[string]interface{}) error { return abort }))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != abort {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if a.quit != nil {
+		t.Fatalf("expected no watch to be started after an aborted first load")
+	}
+}
+
+func TestBaseContextStopsLongPoll(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/notifications/v2", func(w http.ResponseWriter, r *http.Request) {
+		// Hold the long poll until the client goes away
+		<-r.Context().Done()
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := InitApollo(Server(ts.URL), AppId("app"), BaseContext(ctx), WithLogger(discard))
+	useRemote(t, a)
+	if err := a.StartWatch(); err != nil {
+		t.Fatalf("StartWatch: %v", err)
+	}
+	a.watchMu.Lock()
+	stopped := a.stopped
+	a.watchMu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher still running after the base context was cancelled")
+	}
+}
+
+func TestSchemaValidatorKeepsPreviousConfig(t *testing.T) {
+	var configurations atomic.Value
+	configurations.Store(`{"port":"8080"}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":` + configurations.Load().(string) + `,"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+
+	var reported error
+	a := InitApollo(Server(ts.URL), AppId("app"), CacheDir(t.TempDir()), WithLogger(discard),
+		SchemaValidator(func(cfg map[string]interface{}) error {
+			if _, ok := cfg["port"]; !ok {
+				return errors.New("port is required")
+			}
+			return nil
+		}),
+		OnError(func(err error) { reported = err }))
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	configurations.Store(`{"host":"db"}`)
+	if _, err := a.load(); err == nil {
+		t.Fatal("expected invalid configuration to fail the load")
+	}
+	if reported == nil || !strings.Contains(reported.Error(), "port is required") {
+		t.Fatalf("expected validation error to be reported, got %v", reported)
+	}
+	if got := a.configCopy()["port"]; got != "8080" {
+		t.Fatalf("expected previous configuration to be kept, got %v", a.configCopy())
+	}
+	cached, err := a.readCache("application")
+	if err != nil || string(cached) != `{"port":"8080"}` {
+		t.Fatalf("expected invalid configuration not to be cached, got %s, %v", cached, err)
+	}
+}
+
+func TestParseStructKeepsPreviousOnFailure(t *testing.T) {
+	type config struct {
+		Name   string            `mapstructure:"name"`
+		Port   int               `mapstructure:"port"`
+		Labels map[string]string `mapstructure:"labels"`
+	}
+	cfg := config{}
+	a := InitApollo(Server("http://localhost"), AppId("app"), Struct(&cfg), WithLogger(discard))
+	good := map[string]interface{}{"name": "svc", "port": 80, "labels": map[string]interface{}{"team": "a"}}
+	if err := a.ParseStruct(nil, good); err != nil {
+		t.Fatalf("ParseStruct: %v", err)
+	}
+
+	// name and labels decode before port fails
+	bad := map[string]interface{}{"name": "other", "port": "not-a-port", "labels": map[string]interface{}{"team": "b"}}
+	if err := a.ParseStruct(nil, bad); err == nil {
+		t.Fatal("expected bad reload to fail")
+	}
+	if cfg.Name != "svc" || cfg.Port != 80 || cfg.Labels["team"] != "a" {
+		t.Fatalf("expected previous struct to be kept, got %+v", cfg)
+	}
+}
+
+func TestInitViperRemoteReadsSynchronously(t *testing.T) {
+	ts := newFakeApollo(`{"timeout":"3s"}`)
+	defer viper.Reset()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	v, err := InitViperRemote(a, viper.KeyDelimiter(":"))
+	if err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	stopWatch(a)
+	if got := v.GetString("timeout"); got != "3s" {
+		t.Fatalf("expected configuration populated on return, got %q", got)
+	}
+
+	ts.Close()
+	a = InitApollo(Server(ts.URL), AppId("app"), WithLogger(discard))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err == nil {
+		t.Fatal("expected an error when apollo can't be read")
+	}
+}
+
+func TestStandbyPromote(t *testing.T) {
+	var configurations atomic.Value
+	configurations.Store(`{"a":"1"}`)
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":` + configurations.Load().(string) + `,"releaseKey":"r1"}`))
+	}))
+	defer ts.Close()
+	defer viper.Reset()
+
+	type config struct {
+		A string `mapstructure:"a"`
+	}
+	cfg := config{}
+	var changes int32
+	a := InitApollo(Server(ts.URL), AppId("app"), Struct(&cfg), Standby(), WithLogger(discard),
+		OnChange(func() { atomic.AddInt32(&changes, 1) }))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	stopWatch(a)
+	if cfg.A != "" || Remote.IsSet("a") {
+		t.Fatalf("expected nothing applied in standby, got struct %+v", cfg)
+	}
+	if keys := a.Keys(); len(keys) != 1 {
+		t.Fatalf("expected warm configuration to be loaded, got keys %v", keys)
+	}
+
+	configurations.Store(`{"a":"2"}`)
+	a.applyChange()
+	if cfg.A != "" || atomic.LoadInt32(&changes) != 0 {
+		t.Fatalf("expected reload in standby not to be applied, got struct %+v", cfg)
+	}
+
+	before := atomic.LoadInt32(&fetches)
+	a.Promote()
+	if cfg.A != "2" || Remote.GetString("a") != "2" || atomic.LoadInt32(&changes) != 1 {
+		t.Fatalf("expected warm configuration applied on promote, got struct %+v", cfg)
+	}
+	if got := atomic.LoadInt32(&fetches); got != before {
+		t.Fatalf("expected promote not to reach apollo, %d fetches", got-before)
+	}
+}
+
+func TestLocalRemotePrecedence(t *testing.T) {
+	ts := newFakeApollo(`{"port":"80","name":"remote"}`)
+	defer ts.Close()
+	viper.Set("port", 8080)
+	viper.Set("debug", true)
+	defer viper.Reset()
+
+	type config struct {
+		Port  int    `mapstructure:"port"`
+		Name  string `mapstructure:"name"`
+		Debug bool   `mapstructure:"debug"`
+	}
+	for _, localFirst := range []bool{false, true} {
+		cfg := config{}
+		opts := []Option{Server(ts.URL), AppId("app"), Struct(&cfg), WithLogger(discard)}
+		if localFirst {
+			opts = append(opts, LocalPrecedence())
+		}
+		a := InitApollo(opts...)
+		v, err := InitViperRemote(a, viper.KeyDelimiter(":"))
+		if err != nil {
+			t.Fatalf("InitViperRemote: %v", err)
+		}
+		stopWatch(a)
+		want := map[bool]int{false: 80, true: 8080}[localFirst]
+		if cfg.Port != want || v.GetInt("port") != want {
+			t.Fatalf("localFirst=%v: expected port %d, got struct %d and viper %d", localFirst, want, cfg.Port, v.GetInt("port"))
+		}
+		if cfg.Name != "remote" || v.GetString("name") != "remote" || !cfg.Debug || !v.GetBool("debug") {
+			t.Fatalf("localFirst=%v: expected keys defined once to be kept, got %+v", localFirst, cfg)
+		}
+	}
+}
+
+func TestNamespacePathEscaping(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+		_, _ = w.Write([]byte(`{"appId":"app","configurations":{"a":"1"}}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("my app"), Cluster("a/b"), NamespaceName("50%.yaml"))
+	if _, err := a.get(a.baseCtx, a.namespacePath("configs", "50%.yaml")); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if want := "/configs/my%20app/a%2Fb/50%25.yaml"; got != want {
+		t.Fatalf("expected path %s, got %s", want, got)
+	}
+}
+
+func TestAfterReload(t *testing.T) {
+	ts := newFakeApollo(`{"host":"db","port":"5432"}`)
+	defer ts.Close()
+
+	type config struct {
+		Addr string `mapstructure:"addr"`
+	}
+	cfg := config{}
+	var reloads int32
+	a := InitApollo(Server(ts.URL), AppId("app"), Struct(&cfg), IgnoreLocalSettings(), WithLogger(discard),
+		AfterReload(func(v *viper.Viper) {
+			atomic.AddInt32(&reloads, 1)
+			v.Set("addr", v.GetString("host")+":"+v.GetString("port"))
+		}))
+	if _, err := InitViperRemote(a, viper.KeyDelimiter(":")); err != nil {
+		t.Fatalf("InitViperRemote: %v", err)
+	}
+	stopWatch(a)
+	if cfg.Addr != "db:5432" {
+		t.Fatalf("expected derived addr in struct, got %q", cfg.Addr)
+	}
+	if atomic.LoadInt32(&reloads) == 0 {
+		t.Fatalf("expected AfterReload to run")
+	}
+}
+
+func TestActiveCluster(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"appId":"app","cluster":"default","configurations":{"a":"1"}}`))
+	}))
+	defer ts.Close()
+
+	a := InitApollo(Server(ts.URL), AppId("app"), Cluster("gray"), WithLogger(discard))
+	if got := a.ActiveCluster(); got != "gray" {
+		t.Fatalf("expected the requested cluster before loading, got %q", got)
+	}
+	if _, err := a.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := a.ActiveCluster(); got != "default" {
+		t.Fatalf("expected the fallback cluster, got %q", got)
+	}
+}
+
+func TestLocalFileError(t *testing.T) {
+	dir := t.TempDir()
+	v := viper.New()
+	v.AddConfigPath(dir)
+	v.SetConfigName("app.json")
+	v.SetConfigType("json")
+	err := localFileError(filepath.Join(dir, "app.json"), v.ReadInConfig())
+	if !errors.Is(err, ErrLocalFileNotFound) || !strings.Contains(err.Error(), dir) {
+		t.Fatalf("expected ErrLocalFileNotFound naming the path, got %v", err)
+	}
+
+	path := filepath.Join(dir, "app.json")
+	if err := os.WriteFile(path, []byte(`{"dev":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := localFileError(path, v.ReadInConfig()); !errors.Is(err, ErrLocalFileParse) {
+		t.Fatalf("expected ErrLocalFileParse, got %v", err)
+	}
+	if err := localFileError(path, os.ErrPermission); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected the file system error kept, got %v", err)
+	}
+}